@@ -16,15 +16,9 @@ type SameSiteLaxMode struct {
 var _ filter.Filter = &SameSiteLaxMode{}
 
 func (f *SameSiteLaxMode) ResponseHeaders(ctx context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
-	for i, cookie := range req.SetCookies() {
+	crw.RewriteSetCookies(func(cookie *http.Cookie) {
 		cookie.SameSite = http.SameSiteLaxMode
 		cookie.HttpOnly = true
-		// The first set-cookie we overwrite the header, the others we append
-		if i == 0 {
-			crw.SetHeader("set-cookie", cookie.String())
-			continue
-		}
-		crw.AppendHeader("set-cookie", cookie.String())
-	}
+	})
 	return nil, nil
 }
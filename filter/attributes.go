@@ -0,0 +1,329 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Well-known Envoy attributes read by the typed accessors of RequestContext.
+// See https://www.envoyproxy.io/docs/envoy/latest/intro/arch_overview/advanced/attributes
+const (
+	AttributeSourceAddress             = "source.address"
+	AttributeSourcePort                = "source.port"
+	AttributeDestinationAddress        = "destination.address"
+	AttributeDestinationPort           = "destination.port"
+	AttributeConnectionMTLS            = "connection.mtls"
+	AttributeConnectionTLSVersion      = "connection.tls_version"
+	AttributeConnectionSubjectPeerCert = "connection.subject_peer_certificate"
+	AttributeConnectionURISANPeerCert  = "connection.uri_san_peer_certificate"
+	AttributeConnectionDNSSANPeerCert  = "connection.dns_san_peer_certificate"
+	AttributeConnectionServerName      = "connection.requested_server_name"
+	AttributeRequestTime               = "request.time"
+	AttributeRequestProtocol           = "request.protocol"
+	AttributeResponseCode              = "response.code"
+	AttributeResponseCodeDetails       = "response.code_details"
+	AttributeRouteName                 = "xds.route_name"
+	AttributeClusterName               = "xds.cluster_name"
+	AttributeVirtualHostName           = "xds.virtual_host_name"
+	AttributeRouteMetadata             = "xds.route_metadata"
+	AttributeClusterMetadata           = "xds.cluster_metadata"
+	AttributeUpstreamHostMetadata      = "xds.upstream_host_metadata"
+)
+
+// wellKnownAttributes lists the attributes of the typed accessors, in the order of AttributeConfig.
+var wellKnownAttributes = []string{
+	AttributeSourceAddress,
+	AttributeSourcePort,
+	AttributeDestinationAddress,
+	AttributeDestinationPort,
+	AttributeConnectionMTLS,
+	AttributeConnectionTLSVersion,
+	AttributeConnectionSubjectPeerCert,
+	AttributeConnectionURISANPeerCert,
+	AttributeConnectionDNSSANPeerCert,
+	AttributeConnectionServerName,
+	AttributeRequestTime,
+	AttributeRequestProtocol,
+	AttributeResponseCode,
+	AttributeResponseCodeDetails,
+	AttributeRouteName,
+	AttributeClusterName,
+	AttributeVirtualHostName,
+	AttributeRouteMetadata,
+	AttributeClusterMetadata,
+	AttributeUpstreamHostMetadata,
+}
+
+// ErrAttributeNotConfigured is returned by the typed attribute accessors when Envoy did not send the attribute.
+var ErrAttributeNotConfigured = errors.New("attribute not configured")
+
+// isResponseAttribute reports whether the attribute is only known once the response started, so it must be listed in
+// response_attributes rather than request_attributes.
+func isResponseAttribute(name string) bool {
+	return strings.HasPrefix(name, "response.") || name == AttributeUpstreamHostMetadata || strings.HasPrefix(name, "upstream.")
+}
+
+// AttributeConfig returns the request_attributes and response_attributes of the Envoy ext_proc filter sending the
+// given attributes, or all the attributes of the typed accessors if none is given, e.g.
+//
+//	request_attributes:
+//	- source.address
+//	response_attributes:
+//	- response.code
+func AttributeConfig(names ...string) string {
+	if len(names) == 0 {
+		names = wellKnownAttributes
+	}
+	var request, response []string
+	for _, name := range names {
+		if isResponseAttribute(name) {
+			response = append(response, name)
+		} else {
+			request = append(request, name)
+		}
+	}
+
+	var b strings.Builder
+	for _, list := range []struct {
+		key   string
+		names []string
+	}{{"request_attributes", request}, {"response_attributes", response}} {
+		if len(list.names) == 0 {
+			continue
+		}
+		b.WriteString(list.key + ":\n")
+		for _, name := range list.names {
+			b.WriteString("- " + name + "\n")
+		}
+	}
+	return b.String()
+}
+
+// typedAttribute returns the value of the attribute, or an error wrapping ErrAttributeNotConfigured naming the list
+// of the ext_proc filter to configure.
+func (r *RequestContext) typedAttribute(name string) (*structpb.Value, error) {
+	v, ok := r.Attribute(name)
+	if !ok {
+		list := "request_attributes"
+		if isResponseAttribute(name) {
+			list = "response_attributes"
+		}
+		return nil, fmt.Errorf("%s: %w, add it to %s of the ext_proc filter", name, ErrAttributeNotConfigured, list)
+	}
+	return v, nil
+}
+
+func (r *RequestContext) stringAttribute(name string) (string, error) {
+	v, err := r.typedAttribute(name)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.GetKind().(*structpb.Value_StringValue)
+	if !ok {
+		return "", fmt.Errorf("%s: unexpected value %v, want a string", name, v.AsInterface())
+	}
+	return s.StringValue, nil
+}
+
+// intAttribute accepts numbers as well as integers formatted as strings, the way Envoy serializes integer attributes.
+func (r *RequestContext) intAttribute(name string) (int, error) {
+	v, err := r.typedAttribute(name)
+	if err != nil {
+		return 0, err
+	}
+	switch kind := v.GetKind().(type) {
+	case *structpb.Value_NumberValue:
+		return int(kind.NumberValue), nil
+	case *structpb.Value_StringValue:
+		if n, err := strconv.Atoi(kind.StringValue); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: unexpected value %v, want a number", name, v.AsInterface())
+}
+
+func (r *RequestContext) boolAttribute(name string) (bool, error) {
+	v, err := r.typedAttribute(name)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.GetKind().(*structpb.Value_BoolValue)
+	if !ok {
+		return false, fmt.Errorf("%s: unexpected value %v, want a bool", name, v.AsInterface())
+	}
+	return b.BoolValue, nil
+}
+
+// timeAttribute accepts an RFC 3339 string, the way Envoy serializes timestamp attributes, as well as a
+// google.protobuf.Timestamp encoded as a struct of seconds and nanos.
+func (r *RequestContext) timeAttribute(name string) (time.Time, error) {
+	v, err := r.typedAttribute(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch kind := v.GetKind().(type) {
+	case *structpb.Value_StringValue:
+		t, err := time.Parse(time.RFC3339Nano, kind.StringValue)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", name, err)
+		}
+		return t, nil
+	case *structpb.Value_StructValue:
+		fields := kind.StructValue.GetFields()
+		if seconds, ok := fields["seconds"]; ok {
+			return time.Unix(int64(seconds.GetNumberValue()), int64(fields["nanos"].GetNumberValue())).UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s: unexpected value %v, want a timestamp", name, v.AsInterface())
+}
+
+// metadataAttribute accepts a struct as well as a string holding a JSON object or an envoy.config.core.v3.Metadata
+// in the protobuf text format, the way Envoy serializes message attributes. The metadata message is converted to a
+// struct with the field names of the proto, e.g. "filter_metadata".
+func (r *RequestContext) metadataAttribute(name string) (*structpb.Struct, error) {
+	v, err := r.typedAttribute(name)
+	if err != nil {
+		return nil, err
+	}
+	switch kind := v.GetKind().(type) {
+	case *structpb.Value_StructValue:
+		return kind.StructValue, nil
+	case *structpb.Value_StringValue:
+		s := &structpb.Struct{}
+		if err := protojson.Unmarshal([]byte(kind.StringValue), s); err == nil {
+			return s, nil
+		}
+		md := &corev3.Metadata{}
+		if err := prototext.Unmarshal([]byte(kind.StringValue), md); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(md)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := protojson.Unmarshal(b, s); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("%s: unexpected value %v, want a struct", name, v.AsInterface())
+}
+
+// SourceAddress returns the downstream address of the connection, e.g. "10.0.0.1:52000".
+func (r *RequestContext) SourceAddress() (string, error) {
+	return r.stringAttribute(AttributeSourceAddress)
+}
+
+// SourcePort returns the downstream port of the connection.
+func (r *RequestContext) SourcePort() (int, error) {
+	return r.intAttribute(AttributeSourcePort)
+}
+
+// DestinationAddress returns the local address of the connection, e.g. "10.0.0.2:443".
+func (r *RequestContext) DestinationAddress() (string, error) {
+	return r.stringAttribute(AttributeDestinationAddress)
+}
+
+// DestinationPort returns the local port of the connection.
+func (r *RequestContext) DestinationPort() (int, error) {
+	return r.intAttribute(AttributeDestinationPort)
+}
+
+// ConnectionMTLS reports whether the downstream connection uses mutual TLS.
+func (r *RequestContext) ConnectionMTLS() (bool, error) {
+	return r.boolAttribute(AttributeConnectionMTLS)
+}
+
+// ConnectionTLSVersion returns the TLS version of the downstream connection, e.g. "TLSv1.3".
+func (r *RequestContext) ConnectionTLSVersion() (string, error) {
+	return r.stringAttribute(AttributeConnectionTLSVersion)
+}
+
+// PeerCertificateSubject returns the subject of the downstream peer certificate.
+func (r *RequestContext) PeerCertificateSubject() (string, error) {
+	return r.stringAttribute(AttributeConnectionSubjectPeerCert)
+}
+
+// PeerCertificateSANs returns the URI and DNS subject alternative names of the downstream peer certificate.
+// Envoy only sends the first SAN of each kind. It returns an error if neither attribute is configured.
+func (r *RequestContext) PeerCertificateSANs() ([]string, error) {
+	var sans []string
+	var errs []error
+	for _, name := range []string{AttributeConnectionURISANPeerCert, AttributeConnectionDNSSANPeerCert} {
+		san, err := r.stringAttribute(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if san != "" {
+			sans = append(sans, san)
+		}
+	}
+	if len(errs) == 2 {
+		return nil, errors.Join(errs...)
+	}
+	return sans, nil
+}
+
+// RequestedServerName returns the SNI of the downstream TLS connection.
+func (r *RequestContext) RequestedServerName() (string, error) {
+	return r.stringAttribute(AttributeConnectionServerName)
+}
+
+// RequestTime returns the time of the first byte received by Envoy.
+func (r *RequestContext) RequestTime() (time.Time, error) {
+	return r.timeAttribute(AttributeRequestTime)
+}
+
+// RequestProtocol returns the protocol of the request, e.g. "HTTP/1.1" or "HTTP/2".
+func (r *RequestContext) RequestProtocol() (string, error) {
+	return r.stringAttribute(AttributeRequestProtocol)
+}
+
+// ResponseCode returns the response status as known by Envoy, including local replies.
+func (r *RequestContext) ResponseCode() (int, error) {
+	return r.intAttribute(AttributeResponseCode)
+}
+
+// ResponseCodeDetails returns the details of the response status, e.g. "via_upstream".
+func (r *RequestContext) ResponseCodeDetails() (string, error) {
+	return r.stringAttribute(AttributeResponseCodeDetails)
+}
+
+// RouteName returns the name of the route matched by the request.
+func (r *RequestContext) RouteName() (string, error) {
+	return r.stringAttribute(AttributeRouteName)
+}
+
+// ClusterName returns the name of the upstream cluster of the route.
+func (r *RequestContext) ClusterName() (string, error) {
+	return r.stringAttribute(AttributeClusterName)
+}
+
+// VirtualHostName returns the name of the virtual host matched by the request.
+func (r *RequestContext) VirtualHostName() (string, error) {
+	return r.stringAttribute(AttributeVirtualHostName)
+}
+
+// RouteMetadata returns the metadata of the route matched by the request.
+func (r *RequestContext) RouteMetadata() (*structpb.Struct, error) {
+	return r.metadataAttribute(AttributeRouteMetadata)
+}
+
+// ClusterMetadata returns the metadata of the upstream cluster of the route.
+func (r *RequestContext) ClusterMetadata() (*structpb.Struct, error) {
+	return r.metadataAttribute(AttributeClusterMetadata)
+}
+
+// UpstreamHostMetadata returns the metadata of the upstream host selected for the request.
+func (r *RequestContext) UpstreamHostMetadata() (*structpb.Struct, error) {
+	return r.metadataAttribute(AttributeUpstreamHostMetadata)
+}
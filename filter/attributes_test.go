@@ -0,0 +1,133 @@
+package filter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestTypedAttributes(t *testing.T) {
+	routeMetadata, err := structpb.NewStruct(map[string]any{"team": "payments"})
+	require.NoError(t, err)
+	req := filter.NewRequestContext()
+	req.Attributes = map[string]*structpb.Struct{
+		"envoy.filters.http.ext_proc": {Fields: map[string]*structpb.Value{
+			filter.AttributeSourceAddress:            structpb.NewStringValue("10.0.0.1:52000"),
+			filter.AttributeSourcePort:               structpb.NewNumberValue(52000),
+			filter.AttributeConnectionMTLS:           structpb.NewBoolValue(true),
+			filter.AttributeConnectionURISANPeerCert: structpb.NewStringValue("spiffe://cluster.local/ns/default/sa/web"),
+			filter.AttributeRequestTime:              structpb.NewStringValue("2024-03-01T10:00:00.123456+00:00"),
+			filter.AttributeRouteName:                structpb.NewStringValue("api"),
+			filter.AttributeRouteMetadata:            structpb.NewStructValue(routeMetadata),
+			filter.AttributeClusterName:              structpb.NewNumberValue(1),
+		}},
+	}
+
+	address, err := req.SourceAddress()
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1:52000", address)
+
+	port, err := req.SourcePort()
+	require.NoError(t, err)
+	require.Equal(t, 52000, port)
+
+	mtls, err := req.ConnectionMTLS()
+	require.NoError(t, err)
+	require.True(t, mtls)
+
+	sans, err := req.PeerCertificateSANs()
+	require.NoError(t, err)
+	require.Equal(t, []string{"spiffe://cluster.local/ns/default/sa/web"}, sans)
+
+	requestTime, err := req.RequestTime()
+	require.NoError(t, err)
+	require.True(t, time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC).Equal(requestTime))
+
+	route, err := req.RouteName()
+	require.NoError(t, err)
+	require.Equal(t, "api", route)
+
+	md, err := req.RouteMetadata()
+	require.NoError(t, err)
+	require.Equal(t, "payments", md.GetFields()["team"].GetStringValue())
+
+	_, err = req.ClusterName()
+	require.ErrorContains(t, err, "xds.cluster_name: unexpected value 1, want a string")
+
+	_, err = req.ConnectionTLSVersion()
+	require.ErrorIs(t, err, filter.ErrAttributeNotConfigured)
+	require.ErrorContains(t, err, "add it to request_attributes")
+
+	_, err = req.ResponseCode()
+	require.ErrorIs(t, err, filter.ErrAttributeNotConfigured)
+	require.ErrorContains(t, err, "add it to response_attributes")
+}
+
+func TestAttributeConfig(t *testing.T) {
+	require.Equal(t, "request_attributes:\n- source.address\n- xds.route_name\nresponse_attributes:\n- response.code\n",
+		filter.AttributeConfig(filter.AttributeSourceAddress, filter.AttributeResponseCode, filter.AttributeRouteName))
+	require.Contains(t, filter.AttributeConfig(), "- connection.mtls\n")
+}
+
+func TestAttributeEncodings(t *testing.T) {
+	newRequest := func(name string, v *structpb.Value) *filter.RequestContext {
+		req := filter.NewRequestContext()
+		req.Attributes = map[string]*structpb.Struct{
+			"envoy.filters.http.ext_proc": {Fields: map[string]*structpb.Value{name: v}},
+		}
+		return req
+	}
+	timestamp, err := structpb.NewStruct(map[string]any{"seconds": 1709287200, "nanos": 123456000})
+	require.NoError(t, err)
+	requestTime := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+
+	for _, tt := range []struct {
+		name  string
+		value *structpb.Value
+	}{
+		{name: "string", value: structpb.NewStringValue("2024-03-01T10:00:00.123456+00:00")},
+		{name: "timestamp", value: structpb.NewStructValue(timestamp)},
+	} {
+		t.Run("request time "+tt.name, func(t *testing.T) {
+			got, err := newRequest(filter.AttributeRequestTime, tt.value).RequestTime()
+			require.NoError(t, err)
+			require.True(t, requestTime.Equal(got), got)
+		})
+	}
+
+	t.Run("port formatted as a string", func(t *testing.T) {
+		port, err := newRequest(filter.AttributeSourcePort, structpb.NewStringValue("52000")).SourcePort()
+		require.NoError(t, err)
+		require.Equal(t, 52000, port)
+	})
+
+	for _, tt := range []struct {
+		name  string
+		value *structpb.Value
+		path  []string
+	}{{
+		name:  "json",
+		value: structpb.NewStringValue(`{"filter_metadata":{"envoy.lb":{"canary":true}}}`),
+		path:  []string{"filter_metadata", "envoy.lb", "canary"},
+	}, {
+		name:  "text format",
+		value: structpb.NewStringValue(`filter_metadata { key: "envoy.lb" value { fields { key: "canary" value { bool_value: true } } } }`),
+		path:  []string{"filter_metadata", "envoy.lb", "canary"},
+	}} {
+		t.Run("metadata "+tt.name, func(t *testing.T) {
+			md, err := newRequest(filter.AttributeClusterMetadata, tt.value).ClusterMetadata()
+			require.NoError(t, err)
+			v := structpb.NewStructValue(md)
+			for _, field := range tt.path {
+				v = v.GetStructValue().GetFields()[field]
+			}
+			require.True(t, v.GetBoolValue())
+		})
+	}
+
+	_, err = newRequest(filter.AttributeRouteMetadata, structpb.NewStringValue("CelMap")).RouteMetadata()
+	require.ErrorContains(t, err, "xds.route_metadata")
+}
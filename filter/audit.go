@@ -0,0 +1,104 @@
+package filter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MutationAction is the kind of a Mutation.
+type MutationAction string
+
+const (
+	MutationSet    MutationAction = "set"
+	MutationAppend MutationAction = "append"
+	MutationRemove MutationAction = "remove"
+	MutationBody   MutationAction = "body"
+)
+
+// Mutation is a mutation made by a filter, recorded in the audit trail of the stream, see RequestContext.Mutations.
+type Mutation struct {
+	Stage string
+	// Filter is the type name of the filter, e.g. "filters.SameSiteLaxMode".
+	Filter string
+	Action MutationAction
+	// Header is the lowercase name of the header or trailer, empty for body mutations.
+	Header string
+	Value  string
+	// Conflict is the earlier mutation of another filter on the same header that this mutation overrides, e.g. the
+	// header set by another filter that this mutation removes. It is nil if there is no conflict.
+	Conflict *Mutation
+}
+
+func (m *Mutation) String() string {
+	s := fmt.Sprintf("%s:%s:%s", m.Stage, m.Filter, m.Action)
+	if m.Header != "" {
+		s += ":" + m.Header
+	}
+	return s
+}
+
+// RecordMutation adds a mutation to the audit trail of the stream and flags its conflict with earlier mutations.
+// It is called by the service for every mutation made on a CommonResponseWriter, filters do not need to call it.
+func (r *RequestContext) RecordMutation(m Mutation) {
+	if m.Header != "" {
+		m.Header = strings.ToLower(m.Header)
+		m.Conflict = r.conflictingMutation(&m)
+	}
+	r.mutations = append(r.mutations, &m)
+}
+
+// conflictingMutation returns the last mutation of another filter on the same headers that m overrides.
+// Appending never conflicts, setting conflicts with a different value set or with a removal, and removing conflicts
+// with a value set or appended.
+func (r *RequestContext) conflictingMutation(m *Mutation) *Mutation {
+	if m.Action == MutationAppend {
+		return nil
+	}
+	for _, prev := range slices.Backward(r.mutations) {
+		if prev.Header != m.Header || mutationTarget(prev.Stage) != mutationTarget(m.Stage) {
+			continue
+		}
+		if prev.Filter == m.Filter {
+			return nil
+		}
+		switch {
+		case m.Action == MutationRemove && prev.Action != MutationRemove,
+			m.Action == MutationSet && prev.Action == MutationRemove,
+			m.Action == MutationSet && prev.Value != m.Value:
+			return prev
+		}
+		return nil
+	}
+	return nil
+}
+
+// mutationTarget returns the headers mutated at a stage: the request or response headers, mutated in the headers and
+// body stages, or the request or response trailers.
+func mutationTarget(stage string) string {
+	switch {
+	case strings.HasSuffix(stage, "Trailers"):
+		return stage
+	case strings.HasPrefix(stage, "Request"):
+		return "RequestHeaders"
+	case strings.HasPrefix(stage, "Response"):
+		return "ResponseHeaders"
+	}
+	return stage
+}
+
+// Mutations returns the audit trail of the stream: every mutation made by the filters, in order.
+func (r *RequestContext) Mutations() []*Mutation {
+	return slices.Clone(r.mutations)
+}
+
+// MutationConflicts returns the mutations of the audit trail overriding a mutation of another filter.
+func (r *RequestContext) MutationConflicts() []*Mutation {
+	var conflicts []*Mutation
+	for _, m := range r.mutations {
+		if m.Conflict != nil {
+			conflicts = append(conflicts, m)
+		}
+	}
+	return conflicts
+}
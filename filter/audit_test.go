@@ -0,0 +1,84 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutationConflicts(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		mutations []filter.Mutation
+		// conflict is the index of the mutation the last mutation conflicts with, or -1.
+		conflict int
+	}{{
+		name: "remove header set by another filter",
+		mutations: []filter.Mutation{
+			{Stage: "RequestHeaders", Filter: "a", Action: filter.MutationSet, Header: "x-user", Value: "1"},
+			{Stage: "RequestHeaders", Filter: "b", Action: filter.MutationRemove, Header: "X-User"},
+		},
+		conflict: 0,
+	}, {
+		name: "overwrite header set by another filter",
+		mutations: []filter.Mutation{
+			{Stage: "ResponseHeaders", Filter: "a", Action: filter.MutationSet, Header: "cache-control", Value: "no-store"},
+			{Stage: "ResponseBody", Filter: "b", Action: filter.MutationSet, Header: "cache-control", Value: "max-age=60"},
+		},
+		conflict: 0,
+	}, {
+		name: "set same value",
+		mutations: []filter.Mutation{
+			{Stage: "RequestHeaders", Filter: "a", Action: filter.MutationSet, Header: "x-user", Value: "1"},
+			{Stage: "RequestHeaders", Filter: "b", Action: filter.MutationSet, Header: "x-user", Value: "1"},
+		},
+		conflict: -1,
+	}, {
+		name: "set header removed by another filter",
+		mutations: []filter.Mutation{
+			{Stage: "RequestHeaders", Filter: "a", Action: filter.MutationRemove, Header: "x-user"},
+			{Stage: "RequestHeaders", Filter: "b", Action: filter.MutationSet, Header: "x-user", Value: "1"},
+		},
+		conflict: 0,
+	}, {
+		name: "append",
+		mutations: []filter.Mutation{
+			{Stage: "ResponseHeaders", Filter: "a", Action: filter.MutationSet, Header: "vary", Value: "accept"},
+			{Stage: "ResponseHeaders", Filter: "b", Action: filter.MutationAppend, Header: "vary", Value: "cookie"},
+		},
+		conflict: -1,
+	}, {
+		name: "same filter",
+		mutations: []filter.Mutation{
+			{Stage: "ResponseHeaders", Filter: "a", Action: filter.MutationSet, Header: "x-cache", Value: "hit"},
+			{Stage: "ResponseHeaders", Filter: "a", Action: filter.MutationSet, Header: "x-cache", Value: "miss"},
+			{Stage: "ResponseHeaders", Filter: "a", Action: filter.MutationRemove, Header: "x-cache"},
+		},
+		conflict: -1,
+	}, {
+		name: "request and response headers",
+		mutations: []filter.Mutation{
+			{Stage: "RequestHeaders", Filter: "a", Action: filter.MutationSet, Header: "x-user", Value: "1"},
+			{Stage: "ResponseHeaders", Filter: "b", Action: filter.MutationRemove, Header: "x-user"},
+		},
+		conflict: -1,
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := filter.NewRequestContext()
+			for _, m := range tt.mutations {
+				req.RecordMutation(m)
+			}
+			mutations := req.Mutations()
+			require.Len(t, mutations, len(tt.mutations))
+			last := mutations[len(mutations)-1]
+			if tt.conflict < 0 {
+				require.Nil(t, last.Conflict)
+				require.Empty(t, req.MutationConflicts())
+				return
+			}
+			require.Same(t, mutations[tt.conflict], last.Conflict)
+			require.Equal(t, []*filter.Mutation{last}, req.MutationConflicts())
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package filter
+
+import (
+	"context"
+	"errors"
+)
+
+// RequestBodyChunkFilter is an optional interface a Filter can implement to process the request body incrementally
+// when the Envoy ext_proc filter is configured with the STREAMED request_body_mode.
+// Each filter receives the chunks in order, as emitted by the previous filter, and decides through the ChunkWriter
+// whether to pass, replace, drop or hold them. The last chunk of the body has ChunkWriter.EndOfStream set.
+type RequestBodyChunkFilter interface {
+	RequestBodyChunk(ctx context.Context, cw *ChunkWriter, req *RequestContext) error
+}
+
+// ResponseBodyChunkFilter is an optional interface a Filter can implement to process the response body incrementally
+// when the Envoy ext_proc filter is configured with the STREAMED response_body_mode.
+// Filters run in the same order as ResponseHeaders, see RequestBodyChunkFilter for the chunk semantics.
+type ResponseBodyChunkFilter interface {
+	ResponseBodyChunk(ctx context.Context, cw *ChunkWriter, req *RequestContext) error
+}
+
+// ErrHoldWithTrailers is the error of a chunk filter holding data back when the body is followed by trailers, see ChunkWriter.Hold.
+var ErrHoldWithTrailers = errors.New("chunk data cannot be held back when the trailers are sent")
+
+// ChunkWriter gives a chunk filter access to the body chunk being processed and collects what the filter emits.
+// Unless the filter calls Replace or Drop, the chunk is passed unchanged to the next filter.
+type ChunkWriter struct {
+	chunk       []byte
+	endOfStream bool
+	output      []byte
+	held        []byte
+	modified    bool
+	trailers    bool
+	err         error
+}
+
+// NewChunkWriter returns a ChunkWriter for the given chunk. Data held back by the filter on previous chunks
+// should already be prepended to chunk.
+func NewChunkWriter(chunk []byte, endOfStream bool) *ChunkWriter {
+	return &ChunkWriter{
+		chunk:       chunk,
+		endOfStream: endOfStream,
+		output:      chunk,
+	}
+}
+
+// Chunk returns the chunk received by the filter, including any data it held back on previous chunks.
+func (cw *ChunkWriter) Chunk() []byte {
+	return cw.chunk
+}
+
+// EndOfStream reports whether this is the last chunk of the body.
+func (cw *ChunkWriter) EndOfStream() bool {
+	return cw.endOfStream
+}
+
+// Replace replaces the chunk emitted to the next filter with data.
+func (cw *ChunkWriter) Replace(data []byte) *ChunkWriter {
+	cw.output = data
+	cw.modified = true
+	return cw
+}
+
+// Drop drops the chunk, nothing is emitted to the next filter.
+func (cw *ChunkWriter) Drop() *ChunkWriter {
+	return cw.Replace(nil)
+}
+
+// WithTrailers marks the body as possibly followed by trailers sent to the processor, when the trailer mode is SEND.
+// Envoy then does not mark any chunk as the last one before the trailers, which cannot carry body data, so Hold is rejected.
+func (cw *ChunkWriter) WithTrailers() *ChunkWriter {
+	cw.trailers = true
+	return cw
+}
+
+// Hold holds data back and prepends it to the next chunk delivered to the filter, e.g. when a token spans two chunks.
+// Hold does not change what is emitted for the current chunk: to hold the whole chunk, call Hold(cw.Chunk()) followed by Drop().
+// Data held on the last chunk of the body is emitted after the output since no further chunk will be delivered.
+// When the trailers are sent, see WithTrailers, nothing is held and the filter fails with ErrHoldWithTrailers. The
+// service also fails the filter with ErrHoldWithTrailers if data is still held when unexpected trailers arrive.
+func (cw *ChunkWriter) Hold(data []byte) *ChunkWriter {
+	if cw.trailers && !cw.endOfStream {
+		cw.err = ErrHoldWithTrailers
+		return cw
+	}
+	cw.held = append(cw.held, data...)
+	return cw
+}
+
+// Err returns ErrHoldWithTrailers if the filter held data back while the trailers are sent, see Hold.
+func (cw *ChunkWriter) Err() error {
+	return cw.err
+}
+
+// Output returns the data emitted to the next filter, including the data held on the last chunk of the body.
+func (cw *ChunkWriter) Output() []byte {
+	if cw.endOfStream && len(cw.held) > 0 {
+		return append(cw.output[:len(cw.output):len(cw.output)], cw.held...)
+	}
+	return cw.output
+}
+
+// Held returns the data held back for the next chunk.
+func (cw *ChunkWriter) Held() []byte {
+	if cw.endOfStream {
+		return nil
+	}
+	return cw.held
+}
+
+// Modified reports whether the filter changed the chunk, either by replacing, dropping or holding data.
+func (cw *ChunkWriter) Modified() bool {
+	return cw.modified || len(cw.held) > 0
+}
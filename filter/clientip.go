@@ -0,0 +1,127 @@
+package filter
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// ClientIPPolicy defines which addresses RequestContext.ClientIP trusts to resolve the IP of the client.
+// The sources are tried in order: x-envoy-external-address, x-forwarded-for and the source.address attribute.
+type ClientIPPolicy struct {
+	// UseExternalAddress trusts the x-envoy-external-address header, set by Envoy to the client address of external
+	// requests when use_remote_address is enabled.
+	UseExternalAddress bool
+	// TrustedHops is the number of proxies in front of Envoy appending to x-forwarded-for, like xff_num_trusted_hops in
+	// Envoy: the client IP is the (TrustedHops+1)th address from the right of x-forwarded-for.
+	TrustedHops int
+	// TrustedCIDRs are the networks of the proxies in front of Envoy: the client IP is the rightmost address of
+	// x-forwarded-for, followed by source.address when configured, that is not in a trusted network, or the leftmost
+	// address when they are all trusted. It takes precedence over TrustedHops.
+	TrustedCIDRs []netip.Prefix
+	// UseSourceAddress trusts the source.address attribute, the downstream address of the connection as resolved by
+	// Envoy. It must be listed in request_attributes of the ext_proc filter.
+	UseSourceAddress bool
+}
+
+// DefaultClientIPPolicy only trusts the addresses resolved by Envoy, since x-forwarded-for can be forged by clients
+// unless the proxies in front of Envoy are configured.
+var DefaultClientIPPolicy = ClientIPPolicy{UseExternalAddress: true, UseSourceAddress: true}
+
+// SetClientIPPolicy sets the policy of ClientIP. It must be called before ClientIP is first called.
+func (r *RequestContext) SetClientIPPolicy(policy ClientIPPolicy) {
+	r.clientIPPolicy = &policy
+}
+
+// ClientIP returns the IP of the client according to the trust policy set by SetClientIPPolicy, or
+// DefaultClientIPPolicy. The returned address is invalid if no trusted source resolved it. It is computed once per
+// stream, when the request headers are available, and shared by every filter.
+func (r *RequestContext) ClientIP() netip.Addr {
+	if r.clientIP != nil {
+		return *r.clientIP
+	}
+	policy := DefaultClientIPPolicy
+	if r.clientIPPolicy != nil {
+		policy = *r.clientIPPolicy
+	}
+	ip := policy.resolve(r)
+	if len(r.RequestHeaders) > 0 {
+		r.clientIP = &ip
+	}
+	return ip
+}
+
+func (p ClientIPPolicy) resolve(r *RequestContext) netip.Addr {
+	if p.UseExternalAddress {
+		if ip, ok := parseIP(r.RequestHeader("x-envoy-external-address")); ok {
+			return ip
+		}
+	}
+
+	var xff []string
+	for _, v := range r.RequestHeaderValues("x-forwarded-for") {
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				xff = append(xff, addr)
+			}
+		}
+	}
+	sourceAddress, _ := r.SourceAddress()
+
+	switch {
+	case len(p.TrustedCIDRs) > 0:
+		chain := xff
+		if sourceAddress != "" {
+			chain = append(chain, sourceAddress)
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			ip, ok := parseIP(chain[i])
+			if !ok {
+				// An invalid address cannot be trusted, and neither can the addresses on its left.
+				return netip.Addr{}
+			}
+			if !p.trusted(ip) {
+				return ip
+			}
+			if i == 0 {
+				// Every address is trusted, the leftmost one is the closest to the client.
+				return ip
+			}
+		}
+	case p.TrustedHops > 0:
+		if i := len(xff) - 1 - p.TrustedHops; i >= 0 {
+			if ip, ok := parseIP(xff[i]); ok {
+				return ip
+			}
+		}
+	}
+
+	if p.UseSourceAddress {
+		if ip, ok := parseIP(sourceAddress); ok {
+			return ip
+		}
+	}
+	return netip.Addr{}
+}
+
+func (p ClientIPPolicy) trusted(ip netip.Addr) bool {
+	for _, prefix := range p.TrustedCIDRs {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP parses an IP address with an optional port, e.g. "10.0.0.1", "10.0.0.1:80" or "[::1]:80".
+func parseIP(s string) (netip.Addr, bool) {
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return ip.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
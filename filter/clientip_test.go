@@ -0,0 +1,101 @@
+package filter_test
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestClientIP(t *testing.T) {
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	for _, tt := range []struct {
+		name          string
+		policy        *filter.ClientIPPolicy
+		headers       http.Header
+		sourceAddress string
+		want          string
+	}{{
+		name:          "default policy uses the external address",
+		headers:       http.Header{"X-Envoy-External-Address": {"203.0.113.7"}, "X-Forwarded-For": {"198.51.100.1"}},
+		sourceAddress: "10.0.0.1:52000",
+		want:          "203.0.113.7",
+	}, {
+		name:          "default policy falls back to the source address",
+		headers:       http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+		sourceAddress: "10.0.0.1:52000",
+		want:          "10.0.0.1",
+	}, {
+		name:    "default policy does not trust x-forwarded-for",
+		headers: http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+		want:    "invalid IP",
+	}, {
+		name:    "trusted hops",
+		policy:  &filter.ClientIPPolicy{TrustedHops: 1},
+		headers: http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7", "10.1.2.3"}},
+		want:    "203.0.113.7",
+	}, {
+		name:    "more trusted hops than addresses",
+		policy:  &filter.ClientIPPolicy{TrustedHops: 3},
+		headers: http.Header{"X-Forwarded-For": {"203.0.113.7, 10.1.2.3"}},
+		want:    "invalid IP",
+	}, {
+		name:          "trusted CIDRs skip the proxies",
+		policy:        &filter.ClientIPPolicy{TrustedCIDRs: trustedProxies},
+		headers:       http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.1.2.3"}},
+		sourceAddress: "10.0.0.1:52000",
+		want:          "203.0.113.7",
+	}, {
+		name:          "untrusted source address is the client",
+		policy:        &filter.ClientIPPolicy{TrustedCIDRs: trustedProxies},
+		headers:       http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+		sourceAddress: "192.0.2.10:52000",
+		want:          "192.0.2.10",
+	}, {
+		name:          "all trusted chain uses the leftmost address",
+		policy:        &filter.ClientIPPolicy{TrustedCIDRs: trustedProxies, UseSourceAddress: true},
+		headers:       http.Header{"X-Forwarded-For": {"10.1.2.3, 10.2.3.4"}},
+		sourceAddress: "10.0.0.1:52000",
+		want:          "10.1.2.3",
+	}, {
+		name:    "invalid address is not trusted",
+		policy:  &filter.ClientIPPolicy{TrustedCIDRs: trustedProxies},
+		headers: http.Header{"X-Forwarded-For": {"198.51.100.1, unknown, 10.1.2.3"}},
+		want:    "invalid IP",
+	}, {
+		name:    "IPv6 with port",
+		policy:  &filter.ClientIPPolicy{TrustedHops: 1},
+		headers: http.Header{"X-Forwarded-For": {"[2001:db8::1]:443, 10.1.2.3"}},
+		want:    "2001:db8::1",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := filter.NewRequestContext()
+			req.RequestHeaders = tt.headers
+			if tt.sourceAddress != "" {
+				req.Attributes = map[string]*structpb.Struct{
+					"envoy.filters.http.ext_proc": {Fields: map[string]*structpb.Value{
+						filter.AttributeSourceAddress: structpb.NewStringValue(tt.sourceAddress),
+					}},
+				}
+			}
+			if tt.policy != nil {
+				req.SetClientIPPolicy(*tt.policy)
+			}
+			require.Equal(t, tt.want, req.ClientIP().String())
+		})
+	}
+
+	t.Run("computed once per stream", func(t *testing.T) {
+		req := filter.NewRequestContext()
+		require.False(t, req.ClientIP().IsValid())
+
+		req.RequestHeaders.Set("x-envoy-external-address", "203.0.113.7")
+		require.Equal(t, "203.0.113.7", req.ClientIP().String())
+		req.RequestHeaders.Set("x-envoy-external-address", "198.51.100.1")
+		require.Equal(t, "203.0.113.7", req.ClientIP().String())
+	})
+}
@@ -0,0 +1,130 @@
+package filter
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// AddCookie adds a cookie to the cookie request header. Only the name and the value of the cookie are used.
+// Invalid cookies are ignored, see http.Cookie.Valid.
+func (crw *CommonResponseWriter) AddCookie(c *http.Cookie) *CommonResponseWriter {
+	pair := (&http.Cookie{Name: c.Name, Value: c.Value, Quoted: c.Quoted}).String()
+	if pair == "" {
+		return crw
+	}
+	return crw.setHeaderValues("cookie", []string{strings.Join(append(cookiePairs(crw.header), pair), "; ")})
+}
+
+// RemoveCookie removes the cookies with the given name from the cookie request header.
+func (crw *CommonResponseWriter) RemoveCookie(name string) *CommonResponseWriter {
+	pairs := slices.DeleteFunc(cookiePairs(crw.header), func(pair string) bool {
+		n, _, _ := strings.Cut(pair, "=")
+		return n == name
+	})
+	if len(pairs) == 0 {
+		return crw.setHeaderValues("cookie", nil)
+	}
+	return crw.setHeaderValues("cookie", []string{strings.Join(pairs, "; ")})
+}
+
+// cookiePairs returns the name=value pairs of the cookie headers.
+func cookiePairs(header http.Header) []string {
+	var pairs []string
+	for _, line := range header.Values("cookie") {
+		for pair := range strings.SplitSeq(line, ";") {
+			if pair = strings.TrimSpace(pair); pair != "" {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	return pairs
+}
+
+// AddSetCookie adds a set-cookie response header. Invalid cookies are ignored, see http.Cookie.Valid.
+func (crw *CommonResponseWriter) AddSetCookie(c *http.Cookie) *CommonResponseWriter {
+	if v := c.String(); v != "" {
+		crw.setHeaderValues("set-cookie", append(slices.Clone(crw.header.Values("set-cookie")), v))
+	}
+	return crw
+}
+
+// ReplaceSetCookie replaces the set-cookie response headers of the cookie with the same name, or adds it if there is
+// none. Invalid cookies are ignored, see http.Cookie.Valid.
+func (crw *CommonResponseWriter) ReplaceSetCookie(c *http.Cookie) *CommonResponseWriter {
+	v := c.String()
+	if v == "" {
+		return crw
+	}
+	var values []string
+	replaced := false
+	for _, line := range crw.header.Values("set-cookie") {
+		if setCookieName(line) != c.Name {
+			values = append(values, line)
+			continue
+		}
+		if !replaced {
+			values = append(values, v)
+			replaced = true
+		}
+	}
+	if !replaced {
+		values = append(values, v)
+	}
+	return crw.setHeaderValues("set-cookie", values)
+}
+
+// DeleteSetCookie removes the set-cookie response headers of the cookie with the given name. To delete the cookie in
+// the browser, use ReplaceSetCookie with a cookie with MaxAge -1 instead.
+func (crw *CommonResponseWriter) DeleteSetCookie(name string) *CommonResponseWriter {
+	values := slices.DeleteFunc(slices.Clone(crw.header.Values("set-cookie")), func(line string) bool {
+		return setCookieName(line) == name
+	})
+	return crw.setHeaderValues("set-cookie", values)
+}
+
+// RewriteSetCookie calls fn on the cookies of the set-cookie response headers with the given name and regenerates the
+// headers from the modified cookies.
+func (crw *CommonResponseWriter) RewriteSetCookie(name string, fn func(c *http.Cookie)) *CommonResponseWriter {
+	return crw.rewriteSetCookies(func(c *http.Cookie) bool {
+		if c.Name != name {
+			return false
+		}
+		fn(c)
+		return true
+	})
+}
+
+// RewriteSetCookies calls fn on every cookie of the set-cookie response headers and regenerates the headers from the
+// modified cookies, e.g. to set the SameSite attribute of all cookies.
+func (crw *CommonResponseWriter) RewriteSetCookies(fn func(c *http.Cookie)) *CommonResponseWriter {
+	return crw.rewriteSetCookies(func(c *http.Cookie) bool {
+		fn(c)
+		return true
+	})
+}
+
+// rewriteSetCookies regenerates the set-cookie headers of the cookies rewritten by fn. The other headers, and those
+// failing to be parsed, are kept as they are.
+func (crw *CommonResponseWriter) rewriteSetCookies(fn func(c *http.Cookie) bool) *CommonResponseWriter {
+	values := slices.Clone(crw.header.Values("set-cookie"))
+	for i, line := range values {
+		c, err := http.ParseSetCookie(line)
+		if err != nil || !fn(c) {
+			continue
+		}
+		if v := c.String(); v != "" {
+			values[i] = v
+		}
+	}
+	return crw.setHeaderValues("set-cookie", values)
+}
+
+// setCookieName returns the name of the cookie of a set-cookie header, or "" if it fails to be parsed.
+func setCookieName(line string) string {
+	c, err := http.ParseSetCookie(line)
+	if err != nil {
+		return ""
+	}
+	return c.Name
+}
@@ -0,0 +1,85 @@
+package filter_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookies(t *testing.T) {
+	t.Run("request cookies", func(t *testing.T) {
+		req := filter.NewRequestContext()
+		req.RequestHeaders.Set("cookie", "session=abc; locale=en-GB")
+		require.Len(t, req.Cookies(), 2)
+
+		crw := filter.NewCommonResponseWriter(req.RequestHeaders)
+		crw.RemoveCookie("session").AddCookie(&http.Cookie{Name: "variant", Value: "b"})
+		require.Equal(t, "locale=en-GB; variant=b", req.RequestHeader("cookie"))
+		_, ok := req.GetCookie("session")
+		require.False(t, ok)
+		variant, ok := req.GetCookie("variant")
+		require.True(t, ok)
+		require.Equal(t, "b", variant.Value)
+
+		crw.RemoveCookie("locale").RemoveCookie("variant")
+		require.Empty(t, req.RequestHeaderValues("cookie"))
+		require.Empty(t, req.Cookies())
+		require.Equal(t, []string{"cookie"}, crw.Coalesce().CommonResponse().GetHeaderMutation().GetRemoveHeaders())
+	})
+
+	t.Run("set-cookie headers", func(t *testing.T) {
+		req := filter.NewRequestContext()
+		req.ResponseHeaders["Set-Cookie"] = []string{
+			"session=abc; Path=/; Secure",
+			"locale=en-GB; Path=/",
+			"cur=EUR; Path=/",
+		}
+		require.Len(t, req.SetCookies(), 3)
+
+		crw := filter.NewCommonResponseWriter(req.ResponseHeaders)
+		crw.RewriteSetCookie("session", func(c *http.Cookie) {
+			c.HttpOnly = true
+		})
+		crw.ReplaceSetCookie(&http.Cookie{Name: "locale", Value: "de-CH"})
+		crw.DeleteSetCookie("cur")
+		crw.AddSetCookie(&http.Cookie{Name: "cur", Value: "CHF"})
+		crw.AddSetCookie(&http.Cookie{Name: "invalid name", Value: "1"})
+		require.Equal(t, []string{
+			"session=abc; Path=/; HttpOnly; Secure",
+			"locale=de-CH",
+			"cur=CHF",
+		}, req.ResponseHeaderValues("set-cookie"))
+
+		cookies := req.SetCookies()
+		require.Len(t, cookies, 3)
+		require.True(t, cookies[0].HttpOnly)
+		require.Equal(t, "de-CH", cookies[1].Value)
+		require.Equal(t, "CHF", cookies[2].Value)
+
+		setHeaders := crw.Coalesce().CommonResponse().GetHeaderMutation().GetSetHeaders()
+		require.Len(t, setHeaders, 3)
+		require.Equal(t, "set-cookie", setHeaders[0].GetHeader().GetKey())
+	})
+
+	t.Run("add set-cookie does not write into the original header values", func(t *testing.T) {
+		values := make([]string, 1, 4)
+		values[0] = "session=abc"
+		headers := http.Header{"Set-Cookie": values}
+		crw := filter.NewCommonResponseWriter(headers)
+		crw.AddSetCookie(&http.Cookie{Name: "locale", Value: "en-GB"})
+
+		require.Equal(t, []string{"session=abc", "locale=en-GB"}, headers.Values("set-cookie"))
+		require.Equal(t, []string{"session=abc", ""}, values[:2])
+	})
+
+	t.Run("rewrite all", func(t *testing.T) {
+		headers := http.Header{"Set-Cookie": {"a=1", "b=2"}}
+		crw := filter.NewCommonResponseWriter(headers)
+		crw.RewriteSetCookies(func(c *http.Cookie) {
+			c.SameSite = http.SameSiteLaxMode
+		})
+		require.Equal(t, []string{"a=1; SameSite=Lax", "b=2; SameSite=Lax"}, headers.Values("set-cookie"))
+	})
+}
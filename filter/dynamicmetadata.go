@@ -0,0 +1,68 @@
+package filter
+
+import (
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// DynamicMetadata sets the values of a namespace of the Envoy dynamic metadata.
+// The values can be used by the access logs, the router and the filters that run after ext_proc in Envoy, e.g.
+// %DYNAMIC_METADATA(namespace:key)%. Envoy only accepts the namespaces listed in metadata_options.receiving_namespaces
+// of the ext_proc filter.
+type DynamicMetadata struct {
+	fields *structpb.Struct
+}
+
+// SetString sets key to a string value.
+func (md *DynamicMetadata) SetString(key string, value string) *DynamicMetadata {
+	return md.SetValue(key, structpb.NewStringValue(value))
+}
+
+// SetNumber sets key to a number value.
+func (md *DynamicMetadata) SetNumber(key string, value float64) *DynamicMetadata {
+	return md.SetValue(key, structpb.NewNumberValue(value))
+}
+
+// SetBool sets key to a boolean value.
+func (md *DynamicMetadata) SetBool(key string, value bool) *DynamicMetadata {
+	return md.SetValue(key, structpb.NewBoolValue(value))
+}
+
+// SetStruct sets key to a struct value.
+func (md *DynamicMetadata) SetStruct(key string, value *structpb.Struct) *DynamicMetadata {
+	return md.SetValue(key, structpb.NewStructValue(value))
+}
+
+// SetValue sets key to the given value.
+func (md *DynamicMetadata) SetValue(key string, value *structpb.Value) *DynamicMetadata {
+	md.fields.Fields[key] = value
+	return md
+}
+
+// Delete removes key from the namespace.
+func (md *DynamicMetadata) Delete(key string) *DynamicMetadata {
+	delete(md.fields.Fields, key)
+	return md
+}
+
+// DynamicMetadata returns the Envoy dynamic metadata of the given namespace. The metadata is attached to the response
+// sent to Envoy for the current message, including immediate responses.
+func (crw *CommonResponseWriter) DynamicMetadata(namespace string) *DynamicMetadata {
+	if crw.dynamicMetadata == nil {
+		crw.dynamicMetadata = &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+	}
+	ns, ok := crw.dynamicMetadata.Fields[namespace]
+	if !ok {
+		ns = structpb.NewStructValue(&structpb.Struct{Fields: make(map[string]*structpb.Value)})
+		crw.dynamicMetadata.Fields[namespace] = ns
+	}
+	return &DynamicMetadata{fields: ns.GetStructValue()}
+}
+
+// DynamicMetadataStruct returns the dynamic metadata to send in ProcessingResponse.dynamic_metadata, keyed by namespace.
+// It returns nil if no dynamic metadata was set.
+func (crw *CommonResponseWriter) DynamicMetadataStruct() *structpb.Struct {
+	if crw.dynamicMetadata == nil || len(crw.dynamicMetadata.Fields) == 0 {
+		return nil
+	}
+	return crw.dynamicMetadata
+}
@@ -0,0 +1,86 @@
+package filter
+
+import (
+	"unicode/utf8"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+// HeaderEncoding is the field of corev3.HeaderValue holding header values, which depends on the
+// envoy_reloadable_features_send_header_raw_value runtime guard of Envoy: when it is true, Envoy sends and reads header
+// values in raw_value, otherwise in value.
+// See https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/ext_proc/v3/external_processor.proto#envoy-v3-api-msg-service-ext-proc-v3-httpheaders
+type HeaderEncoding int
+
+const (
+	// HeaderEncodingAuto detects the encoding from the headers of the first message of the stream, see
+	// DetectHeaderEncoding.
+	HeaderEncodingAuto HeaderEncoding = iota
+	// HeaderEncodingRawValue writes header values in raw_value, the default of Envoy since v1.27.
+	HeaderEncodingRawValue
+	// HeaderEncodingValue writes header values in value. Values that aren't valid UTF-8 can't be written in the value
+	// string and are written in raw_value instead.
+	HeaderEncodingValue
+)
+
+func (e HeaderEncoding) String() string {
+	switch e {
+	case HeaderEncodingRawValue:
+		return "raw_value"
+	case HeaderEncodingValue:
+		return "value"
+	}
+	return "auto"
+}
+
+// DetectHeaderEncoding returns the encoding of the headers or trailers of a message sent by Envoy, or
+// HeaderEncodingAuto if the message has no header values to detect it from.
+func DetectHeaderEncoding(req *extproc.ProcessingRequest) HeaderEncoding {
+	var headers *corev3.HeaderMap
+	switch msg := req.GetRequest().(type) {
+	case *extproc.ProcessingRequest_RequestHeaders:
+		headers = msg.RequestHeaders.GetHeaders()
+	case *extproc.ProcessingRequest_ResponseHeaders:
+		headers = msg.ResponseHeaders.GetHeaders()
+	case *extproc.ProcessingRequest_RequestTrailers:
+		headers = msg.RequestTrailers.GetTrailers()
+	case *extproc.ProcessingRequest_ResponseTrailers:
+		headers = msg.ResponseTrailers.GetTrailers()
+	}
+	for _, h := range headers.GetHeaders() {
+		switch {
+		case len(h.GetRawValue()) > 0:
+			return HeaderEncodingRawValue
+		case h.GetValue() != "":
+			return HeaderEncodingValue
+		}
+	}
+	return HeaderEncodingAuto
+}
+
+// HeaderValue returns the header value encoded with e. HeaderEncodingAuto encodes in raw_value.
+func (e HeaderEncoding) HeaderValue(key string, value string) *corev3.HeaderValue {
+	if e == HeaderEncodingValue && utf8.ValidString(value) {
+		return &corev3.HeaderValue{Key: key, Value: value}
+	}
+	return &corev3.HeaderValue{Key: key, RawValue: []byte(value)}
+}
+
+// EncodeHeaderMutation re-encodes the values of the headers set by m with e, e.g. the headers of an immediate
+// response built by a filter.
+func (e HeaderEncoding) EncodeHeaderMutation(m *extproc.HeaderMutation) {
+	for _, h := range m.GetSetHeaders() {
+		if h.GetHeader() != nil {
+			h.Header = e.HeaderValue(h.GetHeader().GetKey(), HeaderValueString(h.GetHeader()))
+		}
+	}
+}
+
+// HeaderValueString returns the value of a header sent by Envoy, from raw_value or value.
+func HeaderValueString(h *corev3.HeaderValue) string {
+	if raw := h.GetRawValue(); len(raw) > 0 {
+		return string(raw)
+	}
+	return h.GetValue()
+}
@@ -0,0 +1,48 @@
+package filter_test
+
+import (
+	"net/http"
+
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderEncoding(t *testing.T) {
+	requestHeaders := func(headers ...*corev3.HeaderValue) *extproc.ProcessingRequest {
+		return &extproc.ProcessingRequest{Request: &extproc.ProcessingRequest_RequestHeaders{
+			RequestHeaders: &extproc.HttpHeaders{Headers: &corev3.HeaderMap{Headers: headers}},
+		}}
+	}
+
+	t.Run("detect", func(t *testing.T) {
+		require.Equal(t, filter.HeaderEncodingRawValue, filter.DetectHeaderEncoding(requestHeaders(&corev3.HeaderValue{Key: ":path", RawValue: []byte("/")})))
+		require.Equal(t, filter.HeaderEncodingValue, filter.DetectHeaderEncoding(requestHeaders(&corev3.HeaderValue{Key: "x-empty"}, &corev3.HeaderValue{Key: ":path", Value: "/"})))
+		require.Equal(t, filter.HeaderEncodingAuto, filter.DetectHeaderEncoding(requestHeaders()))
+		require.Equal(t, filter.HeaderEncodingAuto, filter.DetectHeaderEncoding(&extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_RequestBody{RequestBody: &extproc.HttpBody{}},
+		}))
+	})
+
+	t.Run("writers", func(t *testing.T) {
+		crw := filter.NewCommonResponseWriter(http.Header{}).SetHeaderEncoding(filter.HeaderEncodingValue)
+		crw.SetHeader("x-name", "zürich").SetHeader("x-latin1", "z\xfcrich")
+		setHeaders := crw.CommonResponse().GetHeaderMutation().GetSetHeaders()
+		require.Equal(t, &corev3.HeaderValue{Key: "x-name", Value: "zürich"}, setHeaders[0].GetHeader())
+		require.Equal(t, &corev3.HeaderValue{Key: "x-latin1", RawValue: []byte("z\xfcrich")}, setHeaders[1].GetHeader())
+
+		irw := filter.NewImmediateResponseBuilder().SetHeader("location", "/login")
+		headers := irw.ImmediateResponse().ImmediateResponse.GetHeaders()
+		require.Equal(t, []byte("/login"), headers.GetSetHeaders()[0].GetHeader().GetRawValue())
+		filter.HeaderEncodingValue.EncodeHeaderMutation(headers)
+		require.Equal(t, &corev3.HeaderValue{Key: "location", Value: "/login"}, headers.GetSetHeaders()[0].GetHeader())
+	})
+
+	t.Run("read", func(t *testing.T) {
+		require.Equal(t, "z\xfcrich", filter.HeaderValueString(&corev3.HeaderValue{Key: "x-latin1", RawValue: []byte("z\xfcrich")}))
+		require.Equal(t, "zürich", filter.HeaderValueString(&corev3.HeaderValue{Key: "x-name", Value: "zürich"}))
+	})
+}
@@ -0,0 +1,124 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+// AbortError is returned by a filter to stop processing and reply with an HTTP error, see AbortWithStatus.
+// It is converted to an ImmediateResponse regardless of the error policy of the filter.
+type AbortError struct {
+	Status int
+	Body   []byte
+}
+
+// AbortWithStatus returns an error replying with the given HTTP status, e.g.
+//
+//	return nil, filter.AbortWithStatus(http.StatusForbidden)
+func AbortWithStatus(status int) *AbortError {
+	return &AbortError{Status: status}
+}
+
+// WithBody sets the body of the response.
+func (e *AbortError) WithBody(body []byte) *AbortError {
+	e.Body = body
+	return e
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("aborted with status %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// ImmediateResponse returns the immediate response replying with the status and body of the error.
+func (e *AbortError) ImmediateResponse() *extproc.ProcessingResponse_ImmediateResponse {
+	return NewImmediateResponseBuilder().
+		HTTPStatus(e.Status).
+		Body(e.Body).
+		ImmediateResponse()
+}
+
+// ErrorAction is the action taken when a filter returns an error.
+type ErrorAction int
+
+const (
+	// ErrorActionAbort aborts the gRPC stream. Envoy replies with a 500, or skips the ext_proc filter when
+	// failure_mode_allow is set.
+	ErrorActionAbort ErrorAction = iota
+	// ErrorActionIgnore logs the error and continues with the next filter.
+	ErrorActionIgnore
+	// ErrorActionRespond stops processing and replies with an ImmediateResponse.
+	ErrorActionRespond
+)
+
+// ErrorPolicy defines how the errors returned by a filter are handled.
+type ErrorPolicy struct {
+	Action ErrorAction
+	// Status and Body are the HTTP status and body of the ImmediateResponse sent with ErrorActionRespond.
+	// Status defaults to 500.
+	Status int
+	Body   []byte
+}
+
+var (
+	// FailClosed aborts the stream when the filter fails. It is the default policy.
+	FailClosed = ErrorPolicy{Action: ErrorActionAbort}
+	// FailOpen ignores the errors of the filter and continues with the next filter.
+	FailOpen = ErrorPolicy{Action: ErrorActionIgnore}
+)
+
+// RespondOnError replies with the given HTTP status and body when the filter fails.
+func RespondOnError(status int, body []byte) ErrorPolicy {
+	return ErrorPolicy{
+		Action: ErrorActionRespond,
+		Status: status,
+		Body:   body,
+	}
+}
+
+// ErrorPolicyProvider is implemented by filters defining their own error policy, see WithErrorPolicy.
+type ErrorPolicyProvider interface {
+	ErrorPolicy() ErrorPolicy
+}
+
+// PolicyFilter is a Filter with an error policy, see WithErrorPolicy.
+type PolicyFilter struct {
+	filter Filter
+	policy ErrorPolicy
+}
+
+var (
+	_ Filter              = &PolicyFilter{}
+	_ Wrapper             = &PolicyFilter{}
+	_ ErrorPolicyProvider = &PolicyFilter{}
+)
+
+// WithErrorPolicy wraps f to handle its errors with the given policy instead of the default of the service, e.g.
+//
+//	filter.WithErrorPolicy(&MyFilter{}, filter.FailOpen)
+func WithErrorPolicy(f Filter, policy ErrorPolicy) *PolicyFilter {
+	return &PolicyFilter{
+		filter: f,
+		policy: policy,
+	}
+}
+
+// ErrorPolicy returns the error policy of the filter.
+func (p *PolicyFilter) ErrorPolicy() ErrorPolicy {
+	return p.policy
+}
+
+// Unwrap returns the wrapped filter.
+func (p *PolicyFilter) Unwrap() Filter {
+	return p.filter
+}
+
+func (p *PolicyFilter) RequestHeaders(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	return p.filter.RequestHeaders(ctx, crw, req)
+}
+
+func (p *PolicyFilter) ResponseHeaders(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	return p.filter.ResponseHeaders(ctx, crw, req)
+}
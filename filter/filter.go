@@ -22,3 +22,34 @@ func (f *NoOpFilter) RequestHeaders(ctx context.Context, crw *CommonResponseWrit
 func (f *NoOpFilter) ResponseHeaders(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
 	return nil, nil
 }
+
+// RequestBodyFilter is an optional interface a Filter can implement to process the request body.
+// The body of the current message is available in RequestContext.RequestBody. When the Envoy ext_proc filter is
+// configured with the BUFFERED request_body_mode, the whole body is delivered in a single message.
+// Filters run in the same order as RequestHeaders and can replace the body with CommonResponseWriter.BodyMutation.
+type RequestBodyFilter interface {
+	RequestBody(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error)
+}
+
+// ResponseBodyFilter is an optional interface a Filter can implement to process the response body.
+// The body of the current message is available in RequestContext.ResponseBody. When the Envoy ext_proc filter is
+// configured with the BUFFERED response_body_mode, the whole body is delivered in a single message.
+// Filters run in the same order as ResponseHeaders and can replace the body with CommonResponseWriter.BodyMutation.
+type ResponseBodyFilter interface {
+	ResponseBody(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error)
+}
+
+// RequestTrailersFilter is an optional interface a Filter can implement to process the request trailers.
+// The trailers are available in RequestContext.RequestTrailers and are mutated with the CommonResponseWriter header methods.
+// Envoy only sends trailers when request_trailer_mode is set to SEND in the ext_proc filter.
+type RequestTrailersFilter interface {
+	RequestTrailers(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error)
+}
+
+// ResponseTrailersFilter is an optional interface a Filter can implement to process the response trailers, e.g. the
+// grpc-status returned by gRPC upstreams. The trailers are available in RequestContext.ResponseTrailers and are mutated
+// with the CommonResponseWriter header methods.
+// Envoy only sends trailers when response_trailer_mode is set to SEND in the ext_proc filter.
+type ResponseTrailersFilter interface {
+	ResponseTrailers(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error)
+}
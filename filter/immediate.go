@@ -0,0 +1,185 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Redirect sets a redirect to location with the given status, one of 301, 302, 303, 307 or 308; other statuses are
+// replaced by 302. Control characters are removed from location and it is escaped, relative references are sent as is
+// and resolved by the client against the request URL.
+func (irw *ImmediateResponseWriter) Redirect(status int, location string) *ImmediateResponseWriter {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		status = http.StatusFound
+	}
+	location = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, location)
+	if u, err := url.Parse(location); err == nil {
+		location = u.String()
+	}
+	return irw.HTTPStatus(status).SetHeader("location", location)
+}
+
+// JSON sets a response with the given status and v encoded as JSON.
+func (irw *ImmediateResponseWriter) JSON(status int, v any) (*ImmediateResponseWriter, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return irw, fmt.Errorf("failed encoding JSON response: %w", err)
+	}
+	return irw.HTTPStatus(status).SetHeader("content-type", "application/json").Body(body), nil
+}
+
+// Problem is an RFC 9457 problem details object, see ImmediateResponseWriter.Problem.
+type Problem struct {
+	// Type is a URI reference identifying the problem type, "about:blank" when empty.
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// Extensions are additional members of the problem details object.
+	Extensions map[string]any
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := maps.Clone(p.Extensions)
+	if members == nil {
+		members = make(map[string]any)
+	}
+	set := func(name string, v string) {
+		if v != "" {
+			members[name] = v
+		}
+	}
+	set("type", p.Type)
+	set("title", p.Title)
+	set("detail", p.Detail)
+	set("instance", p.Instance)
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	return json.Marshal(members)
+}
+
+// Problem sets an RFC 9457 application/problem+json response. The status of the response is the status of the
+// problem, 500 if it is not set, and its title defaults to the status text.
+func (irw *ImmediateResponseWriter) Problem(p Problem) (*ImmediateResponseWriter, error) {
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	if p.Title == "" && p.Type == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	body, err := json.Marshal(&p)
+	if err != nil {
+		return irw, fmt.Errorf("failed encoding problem details: %w", err)
+	}
+	return irw.HTTPStatus(p.Status).SetHeader("content-type", "application/problem+json").Body(body), nil
+}
+
+// GRPCStatus sets a gRPC status response for gRPC clients. Envoy sends it as a trailers-only response with the status
+// code and message; the details of the status are sent in the grpc-status-details-bin header. The HTTP status is the
+// one Envoy maps the gRPC code to, used for non-gRPC clients.
+func (irw *ImmediateResponseWriter) GRPCStatus(st *status.Status) *ImmediateResponseWriter {
+	irw.immediateResponse.ImmediateResponse.GrpcStatus = &extproc.GrpcStatus{Status: uint32(st.Code())}
+	irw.HTTPStatus(grpcToHTTPStatus(st.Code())).Body([]byte(st.Message()))
+	if len(st.Details()) > 0 {
+		if details, err := proto.Marshal(st.Proto()); err == nil {
+			irw.SetHeader("grpc-status-details-bin", base64.RawStdEncoding.EncodeToString(details))
+		}
+	}
+	return irw
+}
+
+// Details sets the details of the immediate response, logged by Envoy as %RESPONSE_CODE_DETAILS%.
+func (irw *ImmediateResponseWriter) Details(details string) *ImmediateResponseWriter {
+	irw.immediateResponse.ImmediateResponse.Details = details
+	return irw
+}
+
+// grpcToHTTPStatus maps a gRPC code to an HTTP status like Envoy does.
+func grpcToHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorPages renders HTML error pages from templates named after the status, e.g. "404.html", falling back to
+// "error.html".
+type ErrorPages struct {
+	templates *template.Template
+}
+
+// ErrorPageData is the data of the error page templates.
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	Data       any
+}
+
+// ParseErrorPages parses the error page templates matching the patterns in fsys, e.g. an embed.FS.
+func ParseErrorPages(fsys fs.FS, patterns ...string) (*ErrorPages, error) {
+	templates, err := template.ParseFS(fsys, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing error pages: %w", err)
+	}
+	return &ErrorPages{templates: templates}, nil
+}
+
+// ErrorPage sets an HTML response with the given status rendered by the error page template of the status.
+func (irw *ImmediateResponseWriter) ErrorPage(pages *ErrorPages, status int, data any) (*ImmediateResponseWriter, error) {
+	tmpl := pages.templates.Lookup(strconv.Itoa(status) + ".html")
+	if tmpl == nil {
+		tmpl = pages.templates.Lookup("error.html")
+	}
+	if tmpl == nil {
+		return irw, fmt.Errorf("no error page for status %d", status)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, ErrorPageData{Status: status, StatusText: http.StatusText(status), Data: data}); err != nil {
+		return irw, fmt.Errorf("failed rendering error page %s: %w", tmpl.Name(), err)
+	}
+	return irw.HTTPStatus(status).SetHeader("content-type", "text/html; charset=utf-8").Body(body.Bytes()), nil
+}
@@ -0,0 +1,96 @@
+package filter_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestImmediateResponseBuilders(t *testing.T) {
+	header := func(irw *filter.ImmediateResponseWriter, key string) string {
+		for _, h := range irw.ImmediateResponse().ImmediateResponse.GetHeaders().GetSetHeaders() {
+			if h.GetHeader().GetKey() == key {
+				return string(h.GetHeader().GetRawValue())
+			}
+		}
+		return ""
+	}
+
+	t.Run("redirect", func(t *testing.T) {
+		irw := filter.NewImmediateResponseBuilder().Redirect(http.StatusPermanentRedirect, "/new path?q=1\r\nx-injected: 1")
+		require.EqualValues(t, http.StatusPermanentRedirect, irw.ImmediateResponse().ImmediateResponse.GetStatus().GetCode())
+		require.Equal(t, "/new%20path?q=1x-injected: 1", header(irw, "location"))
+
+		irw = filter.NewImmediateResponseBuilder().Redirect(http.StatusOK, "https://example.com/")
+		require.EqualValues(t, http.StatusFound, irw.ImmediateResponse().ImmediateResponse.GetStatus().GetCode())
+		require.Equal(t, "https://example.com/", header(irw, "location"))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		irw, err := filter.NewImmediateResponseBuilder().JSON(http.StatusTooManyRequests, map[string]string{"error": "slow down"})
+		require.NoError(t, err)
+		require.Equal(t, "application/json", header(irw, "content-type"))
+		require.JSONEq(t, `{"error":"slow down"}`, string(irw.ImmediateResponse().ImmediateResponse.GetBody()))
+
+		_, err = filter.NewImmediateResponseBuilder().JSON(http.StatusOK, make(chan int))
+		require.Error(t, err)
+	})
+
+	t.Run("problem", func(t *testing.T) {
+		irw, err := filter.NewImmediateResponseBuilder().Problem(filter.Problem{
+			Status:     http.StatusForbidden,
+			Detail:     "Your account has been locked.",
+			Extensions: map[string]any{"account": "42"},
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, http.StatusForbidden, irw.ImmediateResponse().ImmediateResponse.GetStatus().GetCode())
+		require.Equal(t, "application/problem+json", header(irw, "content-type"))
+		require.JSONEq(t, `{"title":"Forbidden","status":403,"detail":"Your account has been locked.","account":"42"}`, string(irw.ImmediateResponse().ImmediateResponse.GetBody()))
+	})
+
+	t.Run("grpc status", func(t *testing.T) {
+		st, err := status.New(codes.ResourceExhausted, "quota exceeded").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(30e9)})
+		require.NoError(t, err)
+		irw := filter.NewImmediateResponseBuilder().GRPCStatus(st).Details("quota_exceeded")
+		resp := irw.ImmediateResponse().ImmediateResponse
+		require.EqualValues(t, codes.ResourceExhausted, resp.GetGrpcStatus().GetStatus())
+		require.EqualValues(t, http.StatusTooManyRequests, resp.GetStatus().GetCode())
+		require.Equal(t, "quota exceeded", string(resp.GetBody()))
+		require.Equal(t, "quota_exceeded", resp.GetDetails())
+
+		details, err := base64.RawStdEncoding.DecodeString(header(irw, "grpc-status-details-bin"))
+		require.NoError(t, err)
+		require.True(t, proto.Equal(st.Proto(), func() proto.Message {
+			got := status.New(codes.OK, "").Proto()
+			require.NoError(t, proto.Unmarshal(details, got))
+			return got
+		}()))
+	})
+
+	t.Run("error page", func(t *testing.T) {
+		pages, err := filter.ParseErrorPages(fstest.MapFS{
+			"errors/404.html":   {Data: []byte(`<h1>Not found: {{.Data}}</h1>`)},
+			"errors/error.html": {Data: []byte(`<h1>{{.Status}} {{.StatusText}}</h1>`)},
+		}, "errors/*.html")
+		require.NoError(t, err)
+
+		irw, err := filter.NewImmediateResponseBuilder().ErrorPage(pages, http.StatusNotFound, "<script>")
+		require.NoError(t, err)
+		require.Equal(t, "text/html; charset=utf-8", header(irw, "content-type"))
+		require.Equal(t, `<h1>Not found: &lt;script&gt;</h1>`, string(irw.ImmediateResponse().ImmediateResponse.GetBody()))
+
+		irw, err = filter.NewImmediateResponseBuilder().ErrorPage(pages, http.StatusBadGateway, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, http.StatusBadGateway, irw.ImmediateResponse().ImmediateResponse.GetStatus().GetCode())
+		require.Equal(t, `<h1>502 Bad Gateway</h1>`, string(irw.ImmediateResponse().ImmediateResponse.GetBody()))
+	})
+}
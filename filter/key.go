@@ -0,0 +1,92 @@
+package filter
+
+import (
+	"fmt"
+)
+
+// Key is a typed key of the request metadata, to exchange values between filters without type assertions.
+// Keys are compared by identity, so two keys with the same name do not collide:
+//
+//	var UserID = filter.NewKey[string]("user_id")
+//
+//	UserID.Set(req, "42")
+//	id, ok := UserID.Get(req)
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns a new key holding values of type T. The name identifies the key when listing or exporting values.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// Name returns the name of the key.
+func (k *Key[T]) Name() string {
+	return k.name
+}
+
+// Get returns the value of the key in the request metadata and whether it is set with a value of type T.
+func (k *Key[T]) Get(req *RequestContext) (T, bool) {
+	var zero T
+	v, ok := req.Metadata().lookup(k)
+	if !ok {
+		return zero, false
+	}
+	if v == nil {
+		// A nil value of an interface type T.
+		return zero, true
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, false
+	}
+	return t, true
+}
+
+// Set sets the value of the key in the request metadata.
+func (k *Key[T]) Set(req *RequestContext, value T) {
+	req.Metadata().Set(k, value)
+}
+
+// Delete removes the key from the request metadata.
+func (k *Key[T]) Delete(req *RequestContext) {
+	req.Metadata().delete(k)
+}
+
+// Value returns the value of the key as an any, see MetadataKey.
+func (k *Key[T]) Value(req *RequestContext) (any, bool) {
+	return req.Metadata().lookup(k)
+}
+
+func (k *Key[T]) String() string {
+	return k.name
+}
+
+// MetadataKey is implemented by every Key regardless of its type, to read values without knowing it, e.g. to export them.
+type MetadataKey interface {
+	Name() string
+	Value(req *RequestContext) (any, bool)
+}
+
+// Values returns the values set in the metadata for debugging, keyed by the name of typed keys or else by the
+// formatted key.
+func (m *Metadata) Values() map[string]any {
+	values := make(map[string]any, len(m.m))
+	for k, v := range m.m {
+		name := fmt.Sprintf("%v", k)
+		if key, ok := k.(MetadataKey); ok {
+			name = key.Name()
+		}
+		values[name] = v
+	}
+	return values
+}
+
+func (m *Metadata) lookup(key any) (any, bool) {
+	v, ok := m.m[key]
+	return v, ok
+}
+
+func (m *Metadata) delete(key any) {
+	delete(m.m, key)
+}
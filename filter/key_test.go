@@ -0,0 +1,42 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	userID := filter.NewKey[string]("user_id")
+	retries := filter.NewKey[int]("retries")
+	req := filter.NewRequestContext()
+
+	_, ok := userID.Get(req)
+	require.False(t, ok)
+
+	userID.Set(req, "42")
+	retries.Set(req, 3)
+	req.Metadata().Set("legacy", true)
+
+	id, ok := userID.Get(req)
+	require.True(t, ok)
+	require.Equal(t, "42", id)
+
+	// A value of another type set with the untyped metadata is not returned.
+	mismatch := filter.NewKey[int]("mismatch")
+	req.Metadata().Set(mismatch, "not an int")
+	_, ok = mismatch.Get(req)
+	require.False(t, ok)
+	mismatch.Delete(req)
+
+	// Keys are compared by identity, not by name.
+	_, ok = filter.NewKey[string]("user_id").Get(req)
+	require.False(t, ok)
+
+	require.Equal(t, map[string]any{"user_id": "42", "retries": 3, "legacy": true}, req.Metadata().Values())
+
+	retries.Delete(req)
+	_, ok = retries.Get(req)
+	require.False(t, ok)
+}
@@ -0,0 +1,186 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+// Condition reports whether a request matches a declarative condition, see When.
+type Condition func(req *RequestContext) bool
+
+// Matcher is implemented by filters that only run for matching requests, see When.
+type Matcher interface {
+	Match(req *RequestContext) bool
+}
+
+// Wrapper is implemented by filters wrapping another filter. The service runs the optional interfaces of the
+// innermost filter, e.g. RequestBodyFilter or Stream, and names its spans after it.
+type Wrapper interface {
+	Unwrap() Filter
+}
+
+// MatchedFilter is a Filter that only runs when all its conditions match the request, see When.
+type MatchedFilter struct {
+	filter     Filter
+	conditions []Condition
+}
+
+var (
+	_ Filter  = &MatchedFilter{}
+	_ Matcher = &MatchedFilter{}
+	_ Wrapper = &MatchedFilter{}
+)
+
+// When wraps f so it only runs for the requests matching all the given conditions, e.g.
+//
+//	filter.When(&MyFilter{}, filter.Authority("www.example.com"), filter.PathPrefix("/api/"))
+//
+// The conditions are evaluated once per stream, normally on the request headers, so a filter that does not match is
+// skipped for every stage of the stream, including Stream.OnStreamComplete.
+func When(f Filter, conditions ...Condition) *MatchedFilter {
+	return &MatchedFilter{
+		filter:     f,
+		conditions: conditions,
+	}
+}
+
+// Match reports whether the request matches all the conditions. The result is computed once per request.
+func (m *MatchedFilter) Match(req *RequestContext) bool {
+	if matched, ok := req.matches[m]; ok {
+		return matched
+	}
+	matched := true
+	for _, cond := range m.conditions {
+		if !cond(req) {
+			matched = false
+			break
+		}
+	}
+	if req.matches == nil {
+		req.matches = make(map[*MatchedFilter]bool)
+	}
+	req.matches[m] = matched
+	return matched
+}
+
+// Unwrap returns the wrapped filter.
+func (m *MatchedFilter) Unwrap() Filter {
+	return m.filter
+}
+
+func (m *MatchedFilter) RequestHeaders(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	if !m.Match(req) {
+		return nil, nil
+	}
+	return m.filter.RequestHeaders(ctx, crw, req)
+}
+
+func (m *MatchedFilter) ResponseHeaders(ctx context.Context, crw *CommonResponseWriter, req *RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	if !m.Match(req) {
+		return nil, nil
+	}
+	return m.filter.ResponseHeaders(ctx, crw, req)
+}
+
+// Authority matches requests whose authority is one of the given values, ignoring the case.
+// A value starting with "*." matches any subdomain, e.g. "*.example.com" matches "www.example.com".
+func Authority(authorities ...string) Condition {
+	return func(req *RequestContext) bool {
+		authority := strings.ToLower(req.Authority())
+		for _, a := range authorities {
+			a = strings.ToLower(a)
+			if suffix, ok := strings.CutPrefix(a, "*"); ok && strings.HasSuffix(authority, suffix) {
+				return true
+			}
+			if authority == a {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathPrefix matches requests whose path starts with one of the given prefixes. The query string is ignored.
+func PathPrefix(prefixes ...string) Condition {
+	return func(req *RequestContext) bool {
+		path := req.URL().Path
+		return slices.ContainsFunc(prefixes, func(prefix string) bool {
+			return strings.HasPrefix(path, prefix)
+		})
+	}
+}
+
+// PathRegex matches requests whose path matches the regular expression. The query string is ignored.
+// It panics if the expression cannot be parsed.
+func PathRegex(expr string) Condition {
+	re := regexp.MustCompile(expr)
+	return func(req *RequestContext) bool {
+		return re.MatchString(req.URL().Path)
+	}
+}
+
+// Method matches requests whose method is one of the given methods.
+func Method(methods ...string) Condition {
+	return func(req *RequestContext) bool {
+		return slices.Contains(methods, req.Method())
+	}
+}
+
+// Header matches requests with any value of the request header key equal to value.
+func Header(key string, value string) Condition {
+	return func(req *RequestContext) bool {
+		return slices.Contains(req.RequestHeaderValues(key), value)
+	}
+}
+
+// HeaderRegex matches requests with any value of the request header key matching the regular expression.
+// It panics if the expression cannot be parsed.
+func HeaderRegex(key string, expr string) Condition {
+	re := regexp.MustCompile(expr)
+	return func(req *RequestContext) bool {
+		return slices.ContainsFunc(req.RequestHeaderValues(key), re.MatchString)
+	}
+}
+
+// HeaderPresent matches requests with the request header key set.
+func HeaderPresent(key string) Condition {
+	return func(req *RequestContext) bool {
+		return len(req.RequestHeaderValues(key)) > 0
+	}
+}
+
+// AttributeEquals matches requests where the Envoy attribute key is set to value, see RequestContext.Attribute.
+// Non-string attributes are compared using their default format, e.g. "true" or "443".
+func AttributeEquals(key string, value string) Condition {
+	return func(req *RequestContext) bool {
+		v, ok := req.Attribute(key)
+		if !ok {
+			return false
+		}
+		return fmt.Sprint(v.AsInterface()) == value
+	}
+}
+
+// Not matches requests that do not match the condition.
+func Not(cond Condition) Condition {
+	return func(req *RequestContext) bool {
+		return !cond(req)
+	}
+}
+
+// AnyOf matches requests matching at least one of the conditions.
+func AnyOf(conditions ...Condition) Condition {
+	return func(req *RequestContext) bool {
+		for _, cond := range conditions {
+			if cond(req) {
+				return true
+			}
+		}
+		return false
+	}
+}
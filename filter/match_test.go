@@ -0,0 +1,104 @@
+package filter_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestConditions(t *testing.T) {
+	req := &filter.RequestContext{
+		RequestHeaders: http.Header{
+			":authority":   []string{"www.example.com"},
+			":method":      []string{"POST"},
+			":path":        []string{"/api/v1/users?id=1"},
+			"X-Experiment": []string{"a", "b"},
+		},
+		Attributes: map[string]*structpb.Struct{
+			"envoy.filters.http.ext_proc": {Fields: map[string]*structpb.Value{
+				"connection.mtls":  structpb.NewBoolValue(true),
+				"destination.port": structpb.NewNumberValue(443),
+			}},
+		},
+	}
+
+	for _, tt := range []struct {
+		name string
+		cond filter.Condition
+		want bool
+	}{
+		{name: "authority", cond: filter.Authority("WWW.example.com"), want: true},
+		{name: "authority wildcard", cond: filter.Authority("*.example.com"), want: true},
+		{name: "authority mismatch", cond: filter.Authority("example.com"), want: false},
+		{name: "path prefix", cond: filter.PathPrefix("/static/", "/api/"), want: true},
+		{name: "path prefix ignores query", cond: filter.PathPrefix("/api/v1/users?"), want: false},
+		{name: "path regex", cond: filter.PathRegex(`^/api/v\d+/`), want: true},
+		{name: "method", cond: filter.Method("GET", "POST"), want: true},
+		{name: "method mismatch", cond: filter.Method("GET"), want: false},
+		{name: "header any value", cond: filter.Header("x-experiment", "b"), want: true},
+		{name: "header regex", cond: filter.HeaderRegex("x-experiment", "^[ab]$"), want: true},
+		{name: "header present", cond: filter.HeaderPresent("x-experiment"), want: true},
+		{name: "header absent", cond: filter.HeaderPresent("x-missing"), want: false},
+		{name: "bool attribute", cond: filter.AttributeEquals("connection.mtls", "true"), want: true},
+		{name: "number attribute", cond: filter.AttributeEquals("destination.port", "443"), want: true},
+		{name: "missing attribute", cond: filter.AttributeEquals("source.port", "443"), want: false},
+		{name: "not", cond: filter.Not(filter.Method("POST")), want: false},
+		{name: "any of", cond: filter.AnyOf(filter.Method("GET"), filter.PathPrefix("/api/")), want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.cond(req))
+		})
+	}
+}
+
+type countingFilter struct {
+	filter.NoOpFilter
+	calls int
+}
+
+func (f *countingFilter) RequestHeaders(ctx context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	f.calls++
+	return nil, nil
+}
+
+func TestWhen(t *testing.T) {
+	t.Run("conditions are evaluated once per request", func(t *testing.T) {
+		evaluated := 0
+		inner := &countingFilter{}
+		f := filter.When(inner, func(req *filter.RequestContext) bool {
+			evaluated++
+			return req.Method() == "GET"
+		})
+		req := filter.NewRequestContext()
+		req.RequestHeaders.Set(":method", "GET")
+		crw := filter.NewCommonResponseWriter(req.RequestHeaders)
+
+		_, err := f.RequestHeaders(context.Background(), crw, req)
+		require.NoError(t, err)
+		req.RequestHeaders.Set(":method", "POST")
+		_, err = f.RequestHeaders(context.Background(), crw, req)
+		require.NoError(t, err)
+
+		require.Equal(t, 1, evaluated)
+		require.Equal(t, 2, inner.calls)
+		require.Same(t, inner, f.Unwrap())
+		require.Empty(t, req.Metadata().Values())
+	})
+
+	t.Run("skips the filter when a condition does not match", func(t *testing.T) {
+		inner := &countingFilter{}
+		f := filter.When(inner, filter.Method("GET"), filter.PathPrefix("/api/"))
+		req := filter.NewRequestContext()
+		req.RequestHeaders.Set(":method", "GET")
+		req.RequestHeaders.Set(":path", "/static/app.js")
+
+		_, err := f.RequestHeaders(context.Background(), filter.NewCommonResponseWriter(req.RequestHeaders), req)
+		require.NoError(t, err)
+		require.Zero(t, inner.calls)
+	})
+}
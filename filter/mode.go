@@ -0,0 +1,121 @@
+package filter
+
+import (
+	extprocfilter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// bodyModePrecedence ranks the body modes when filters declare different ones.
+// Buffered modes win over streamed ones since chunk filters also run on a buffered body, as a single chunk.
+var bodyModePrecedence = map[extprocfilter.ProcessingMode_BodySendMode]int{
+	extprocfilter.ProcessingMode_NONE:                 0,
+	extprocfilter.ProcessingMode_STREAMED:             1,
+	extprocfilter.ProcessingMode_FULL_DUPLEX_STREAMED: 1,
+	extprocfilter.ProcessingMode_GRPC:                 1,
+	extprocfilter.ProcessingMode_BUFFERED_PARTIAL:     2,
+	extprocfilter.ProcessingMode_BUFFERED:             3,
+}
+
+// modeOverride holds the processing mode declarations of the filters, nil fields are undeclared.
+type modeOverride struct {
+	responseHeaders  *bool
+	requestTrailers  *bool
+	responseTrailers *bool
+	requestBody      *extprocfilter.ProcessingMode_BodySendMode
+	responseBody     *extprocfilter.ProcessingMode_BodySendMode
+}
+
+// mergeSend merges a send declaration: a filter needing the message wins over a filter skipping it.
+func mergeSend(current *bool, send bool) *bool {
+	if current != nil && *current {
+		return current
+	}
+	return &send
+}
+
+// mergeBodyMode merges a body mode declaration according to bodyModePrecedence.
+func mergeBodyMode(current *extprocfilter.ProcessingMode_BodySendMode, mode extprocfilter.ProcessingMode_BodySendMode) *extprocfilter.ProcessingMode_BodySendMode {
+	if current != nil && bodyModePrecedence[*current] >= bodyModePrecedence[mode] {
+		return current
+	}
+	return &mode
+}
+
+func (crw *CommonResponseWriter) declareMode() *modeOverride {
+	if crw.modeOverride == nil {
+		crw.modeOverride = &modeOverride{}
+	}
+	return crw.modeOverride
+}
+
+// SendResponseHeaders declares whether the filter needs the response headers of the current request.
+// Declarations are only honored in RequestHeaders and require allow_mode_override in the Envoy ext_proc filter.
+// When filters disagree, a filter needing the response headers wins; filters that do not declare anything do not prevent skipping them.
+func (crw *CommonResponseWriter) SendResponseHeaders(send bool) *CommonResponseWriter {
+	crw.declareMode().responseHeaders = mergeSend(crw.declareMode().responseHeaders, send)
+	return crw
+}
+
+// SendRequestTrailers declares whether the filter needs the request trailers of the current request.
+// See SendResponseHeaders for how declarations are merged.
+func (crw *CommonResponseWriter) SendRequestTrailers(send bool) *CommonResponseWriter {
+	crw.declareMode().requestTrailers = mergeSend(crw.declareMode().requestTrailers, send)
+	return crw
+}
+
+// SendResponseTrailers declares whether the filter needs the response trailers of the current request.
+// See SendResponseHeaders for how declarations are merged.
+func (crw *CommonResponseWriter) SendResponseTrailers(send bool) *CommonResponseWriter {
+	crw.declareMode().responseTrailers = mergeSend(crw.declareMode().responseTrailers, send)
+	return crw
+}
+
+// RequestBodyMode declares how the filter needs the request body of the current request, NONE when it does not need it.
+// When filters disagree, buffered modes win over streamed ones, which win over NONE.
+// Declarations are only honored in RequestHeaders and require allow_mode_override in the Envoy ext_proc filter.
+func (crw *CommonResponseWriter) RequestBodyMode(mode extprocfilter.ProcessingMode_BodySendMode) *CommonResponseWriter {
+	crw.declareMode().requestBody = mergeBodyMode(crw.declareMode().requestBody, mode)
+	return crw
+}
+
+// ResponseBodyMode declares how the filter needs the response body of the current request, NONE when it does not need it.
+// See RequestBodyMode for how declarations are merged.
+func (crw *CommonResponseWriter) ResponseBodyMode(mode extprocfilter.ProcessingMode_BodySendMode) *CommonResponseWriter {
+	crw.declareMode().responseBody = mergeBodyMode(crw.declareMode().responseBody, mode)
+	return crw
+}
+
+// ModeOverride returns the processing mode to send as ProcessingResponse.mode_override, or nil if no filter declared anything.
+// Envoy replaces its whole processing mode with the override, so the fields no filter declared are taken from base,
+// which should match the processing_mode configured in the Envoy ext_proc filter. Without a base, the declarations are
+// ignored and ModeOverride returns nil, since an override would reset the undeclared modes, e.g. a streamed body.
+func (crw *CommonResponseWriter) ModeOverride(base *extprocfilter.ProcessingMode) *extprocfilter.ProcessingMode {
+	m := crw.modeOverride
+	if m == nil || base == nil {
+		return nil
+	}
+	mode := proto.Clone(base).(*extprocfilter.ProcessingMode)
+	if m.responseHeaders != nil {
+		mode.ResponseHeaderMode = sendMode(*m.responseHeaders)
+	}
+	if m.requestTrailers != nil {
+		mode.RequestTrailerMode = sendMode(*m.requestTrailers)
+	}
+	if m.responseTrailers != nil {
+		mode.ResponseTrailerMode = sendMode(*m.responseTrailers)
+	}
+	if m.requestBody != nil {
+		mode.RequestBodyMode = *m.requestBody
+	}
+	if m.responseBody != nil {
+		mode.ResponseBodyMode = *m.responseBody
+	}
+	return mode
+}
+
+func sendMode(send bool) extprocfilter.ProcessingMode_HeaderSendMode {
+	if send {
+		return extprocfilter.ProcessingMode_SEND
+	}
+	return extprocfilter.ProcessingMode_SKIP
+}
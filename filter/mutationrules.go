@@ -0,0 +1,153 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	mutationrulesv3 "github.com/envoyproxy/go-control-plane/envoy/config/common/mutation_rules/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+// routingHeaders can only be mutated with allow_all_routing.
+var routingHeaders = map[string]struct{}{
+	"host":       {},
+	":authority": {},
+	":scheme":    {},
+	":method":    {},
+}
+
+// MutationRules validates header mutations in-process against the mutation_rules of the Envoy ext_proc filter, which
+// Envoy enforces by ignoring or rejecting the mutations.
+// See https://www.envoyproxy.io/docs/envoy/latest/api-v3/config/common/mutation_rules/v3/mutation_rules.proto
+type MutationRules struct {
+	rules              *mutationrulesv3.HeaderMutationRules
+	allowExpression    *regexp.Regexp
+	disallowExpression *regexp.Regexp
+}
+
+// NewMutationRules returns the MutationRules of the given mutation_rules. Nil rules are the Envoy defaults: all headers
+// may be mutated except for host, :authority, :scheme, :method and x-envoy-* headers.
+func NewMutationRules(rules *mutationrulesv3.HeaderMutationRules) (*MutationRules, error) {
+	mr := &MutationRules{rules: rules}
+	var err error
+	if re := rules.GetAllowExpression().GetRegex(); re != "" {
+		if mr.allowExpression, err = regexp.Compile(re); err != nil {
+			return nil, fmt.Errorf("invalid allow_expression: %w", err)
+		}
+	}
+	if re := rules.GetDisallowExpression().GetRegex(); re != "" {
+		if mr.disallowExpression, err = regexp.Compile(re); err != nil {
+			return nil, fmt.Errorf("invalid disallow_expression: %w", err)
+		}
+	}
+	return mr, nil
+}
+
+// MutationViolation is a header mutation that Envoy ignores or rejects.
+type MutationViolation struct {
+	Action MutationAction
+	Header string
+	Reason string
+	// Fails reports whether Envoy fails the request instead of ignoring the mutation: the header is invalid, or the
+	// mutation is disallowed and disallow_is_error is set.
+	Fails bool
+}
+
+func (v *MutationViolation) Error() string {
+	return fmt.Sprintf("%s header %q: %s", v.Action, v.Header, v.Reason)
+}
+
+// Check returns the violation of a mutation of the given header, or nil if Envoy applies it.
+func (mr *MutationRules) Check(action MutationAction, header string, value string) *MutationViolation {
+	name := strings.ToLower(header)
+	violation := func(reason string, fails bool) *MutationViolation {
+		return &MutationViolation{Action: action, Header: name, Reason: reason, Fails: fails}
+	}
+	if !validHeaderName(name) {
+		return violation("invalid header name", true)
+	}
+	if action != MutationRemove && strings.ContainsAny(value, "\x00\r\n") {
+		return violation("invalid header value", true)
+	}
+	if action == MutationRemove && isSystemHeader(name) {
+		return violation("system headers can't be removed", false)
+	}
+	if reason := mr.disallowed(name); reason != "" {
+		return violation(reason, mr.rules.GetDisallowIsError().GetValue())
+	}
+	return nil
+}
+
+// disallowed returns why the mutation rules disallow mutating the header, or "" if they allow it.
+func (mr *MutationRules) disallowed(name string) string {
+	switch {
+	case mr.rules.GetDisallowAll().GetValue():
+		return "disallow_all is set"
+	case mr.disallowExpression != nil && mr.disallowExpression.MatchString(name):
+		return "matches disallow_expression"
+	case mr.allowExpression != nil && mr.allowExpression.MatchString(name):
+		return ""
+	}
+	if _, ok := routingHeaders[name]; ok {
+		if !mr.rules.GetAllowAllRouting().GetValue() {
+			return "routing headers require allow_all_routing"
+		}
+		return ""
+	}
+	if strings.HasPrefix(name, "x-envoy-") && !mr.rules.GetAllowEnvoy().GetValue() {
+		return "x-envoy headers require allow_envoy"
+	}
+	if isSystemHeader(name) && mr.rules.GetDisallowSystem().GetValue() {
+		return "disallow_system is set"
+	}
+	return ""
+}
+
+// CheckHeaderMutation returns the violations of the mutations of m, e.g. the header mutation of a
+// CommonResponseWriter or an ImmediateResponseWriter.
+func (mr *MutationRules) CheckHeaderMutation(m *extproc.HeaderMutation) []*MutationViolation {
+	var violations []*MutationViolation
+	for _, h := range m.GetSetHeaders() {
+		action := MutationSet
+		if h.GetAppendAction() == corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD {
+			action = MutationAppend
+		}
+		value := h.GetHeader().GetValue()
+		if raw := h.GetHeader().GetRawValue(); raw != nil {
+			value = string(raw)
+		}
+		if v := mr.Check(action, h.GetHeader().GetKey(), value); v != nil {
+			violations = append(violations, v)
+		}
+	}
+	for _, h := range m.GetRemoveHeaders() {
+		if v := mr.Check(MutationRemove, h, ""); v != nil {
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// isSystemHeader reports whether the header is a pseudo-header or host.
+func isSystemHeader(name string) bool {
+	return strings.HasPrefix(name, ":") || name == "host"
+}
+
+// validHeaderName reports whether the lowercase name is an HTTP token, optionally prefixed with ":".
+func validHeaderName(name string) bool {
+	name = strings.TrimPrefix(name, ":")
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
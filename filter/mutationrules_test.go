@@ -0,0 +1,95 @@
+package filter_test
+
+import (
+	"testing"
+
+	mutationrulesv3 "github.com/envoyproxy/go-control-plane/envoy/config/common/mutation_rules/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMutationRules(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		rules  *mutationrulesv3.HeaderMutationRules
+		action filter.MutationAction
+		header string
+		value  string
+		// reason is the reason of the violation, or "" if the mutation is allowed.
+		reason string
+		fails  bool
+	}{
+		{name: "default", action: filter.MutationSet, header: "x-user", value: "1"},
+		{name: "path", action: filter.MutationSet, header: ":path", value: "/"},
+		{name: "routing header", action: filter.MutationSet, header: ":authority", value: "example.com", reason: "routing headers require allow_all_routing"},
+		{
+			name:   "allow all routing",
+			rules:  &mutationrulesv3.HeaderMutationRules{AllowAllRouting: wrapperspb.Bool(true)},
+			action: filter.MutationSet, header: "Host", value: "example.com",
+		},
+		{name: "envoy header", action: filter.MutationSet, header: "X-Envoy-Retry-On", value: "5xx", reason: "x-envoy headers require allow_envoy"},
+		{
+			name:   "disallow system",
+			rules:  &mutationrulesv3.HeaderMutationRules{DisallowSystem: wrapperspb.Bool(true), DisallowIsError: wrapperspb.Bool(true)},
+			action: filter.MutationSet, header: ":path", value: "/",
+			reason: "disallow_system is set", fails: true,
+		},
+		{
+			name:   "disallow all",
+			rules:  &mutationrulesv3.HeaderMutationRules{DisallowAll: wrapperspb.Bool(true), AllowExpression: &matcherv3.RegexMatcher{Regex: "^x-"}},
+			action: filter.MutationAppend, header: "x-user", value: "1",
+			reason: "disallow_all is set",
+		},
+		{
+			name:   "allow expression",
+			rules:  &mutationrulesv3.HeaderMutationRules{AllowExpression: &matcherv3.RegexMatcher{Regex: "^x-envoy-"}},
+			action: filter.MutationSet, header: "x-envoy-retry-on", value: "5xx",
+		},
+		{
+			name: "disallow expression",
+			rules: &mutationrulesv3.HeaderMutationRules{
+				AllowExpression:    &matcherv3.RegexMatcher{Regex: "^x-"},
+				DisallowExpression: &matcherv3.RegexMatcher{Regex: "^x-internal-"},
+			},
+			action: filter.MutationRemove, header: "x-internal-id",
+			reason: "matches disallow_expression",
+		},
+		{name: "remove system header", action: filter.MutationRemove, header: ":path", reason: "system headers can't be removed"},
+		{name: "invalid name", action: filter.MutationSet, header: "x user", value: "1", reason: "invalid header name", fails: true},
+		{name: "invalid value", action: filter.MutationSet, header: "x-user", value: "1\r\nx-admin: 1", reason: "invalid header value", fails: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := filter.NewMutationRules(tt.rules)
+			require.NoError(t, err)
+			v := rules.Check(tt.action, tt.header, tt.value)
+			if tt.reason == "" {
+				require.Nil(t, v)
+				return
+			}
+			require.NotNil(t, v)
+			require.Equal(t, tt.reason, v.Reason)
+			require.Equal(t, tt.fails, v.Fails)
+		})
+	}
+
+	t.Run("invalid expression", func(t *testing.T) {
+		_, err := filter.NewMutationRules(&mutationrulesv3.HeaderMutationRules{AllowExpression: &matcherv3.RegexMatcher{Regex: "("}})
+		require.Error(t, err)
+	})
+
+	t.Run("writers", func(t *testing.T) {
+		rules, err := filter.NewMutationRules(nil)
+		require.NoError(t, err)
+
+		crw := filter.NewCommonResponseWriter(nil)
+		crw.RemoveHeaders("x-envoy-upstream-service-time")
+		require.Len(t, rules.CheckHeaderMutation(crw.CommonResponse().GetHeaderMutation()), 1)
+
+		irw := filter.NewImmediateResponseBuilder().SetHeader("location", "/login").AppendHeader("X-Envoy-Foo", "1")
+		violations := rules.CheckHeaderMutation(irw.ImmediateResponse().ImmediateResponse.GetHeaders())
+		require.Len(t, violations, 1)
+		require.Equal(t, `append header "x-envoy-foo": x-envoy headers require allow_envoy`, violations[0].Error())
+	})
+}
@@ -3,11 +3,15 @@ package filter
 import (
 	"fmt"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
@@ -20,13 +24,37 @@ const extProcFilterName = "envoy.filters.http.ext_proc"
 type RequestContext struct {
 	RequestHeaders  http.Header
 	ResponseHeaders http.Header
-	Attributes      map[string]*structpb.Struct
-	url             *url.URL
+	// RequestBody and ResponseBody hold the body of the last body message received in each direction.
+	// Envoy only sends body messages when the matching body mode is enabled in the ext_proc filter.
+	RequestBody      []byte
+	ResponseBody     []byte
+	RequestTrailers  http.Header
+	ResponseTrailers http.Header
+	Attributes       map[string]*structpb.Struct
+	// MetadataContext holds the Envoy filter metadata forwarded per forwarding_namespaces.untyped, keyed by namespace.
+	// TypedMetadataContext holds the typed filter metadata forwarded per forwarding_namespaces.typed.
+	MetadataContext      map[string]*structpb.Struct
+	TypedMetadataContext map[string]*anypb.Any
+	// GRPCMetadata holds the incoming metadata of the gRPC stream, e.g. the grpc_initial_metadata of the ext_proc filter.
+	GRPCMetadata metadata.MD
+	// url caches URL, parsed from the urlPath value of the :path header.
+	url     *url.URL
+	urlPath string
+	// cookies and setCookies cache the cookies parsed from the cookieHeader and setCookieHeader values, they are
+	// parsed again when filters mutate the headers.
 	cookies         []*http.Cookie
+	cookieHeader    []string
 	status          int
 	setCookies      []*http.Cookie
+	setCookieHeader []string
 	metadata        *Metadata
 	startTime       time.Time
+	// clientIP caches ClientIP once the request headers are known.
+	clientIP       *netip.Addr
+	clientIPPolicy *ClientIPPolicy
+	mutations      []*Mutation
+	// matches caches the result of MatchedFilter.Match.
+	matches map[*MatchedFilter]bool
 }
 
 // RequestHeader gets the first value associated with the given key.
@@ -71,6 +99,24 @@ func (r *RequestContext) ResponseHeaderValues(key string) []string {
 	return r.ResponseHeaders.Values(key)
 }
 
+// RequestTrailer gets the first value associated with the given request trailer key.
+// If there are no values associated with the key, RequestTrailer returns "". It is case insensitive.
+func (r *RequestContext) RequestTrailer(key string) string {
+	if r.RequestTrailers == nil {
+		return ""
+	}
+	return r.RequestTrailers.Get(key)
+}
+
+// ResponseTrailer gets the first value associated with the given response trailer key, e.g. ResponseTrailer("grpc-status").
+// If there are no values associated with the key, ResponseTrailer returns "". It is case insensitive.
+func (r *RequestContext) ResponseTrailer(key string) string {
+	if r.ResponseTrailers == nil {
+		return ""
+	}
+	return r.ResponseTrailers.Get(key)
+}
+
 // Attribute returns the value of the given Envoy attribute, e.g. Attribute("source.address").
 // It is only populated when the Envoy ext_proc filter is configured with a matching entry in
 // request_attributes or response_attributes.
@@ -88,6 +134,28 @@ func (r *RequestContext) Attribute(key string) (*structpb.Value, bool) {
 	return v, ok
 }
 
+// MetadataContextValue returns the value of key in the given namespace of the Envoy filter metadata, e.g.
+// MetadataContextValue("envoy.filters.http.rbac", "shadow_effective_policy_id"). It is only populated when the
+// namespace is listed in metadata_options.forwarding_namespaces of the Envoy ext_proc filter.
+func (r *RequestContext) MetadataContextValue(namespace string, key string) (*structpb.Value, bool) {
+	ns, ok := r.MetadataContext[namespace]
+	if !ok {
+		return nil, false
+	}
+	v, ok := ns.GetFields()[key]
+	return v, ok
+}
+
+// GRPCMetadataValue returns the first value of the given key in the incoming gRPC metadata of the stream.
+// If there are no values associated with the key, GRPCMetadataValue returns "". Keys are case insensitive.
+func (r *RequestContext) GRPCMetadataValue(key string) string {
+	values := r.GRPCMetadata.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
 // Scheme returns the scheme of the request (http or https)
 func (r *RequestContext) Scheme() string {
 	return r.RequestHeader(":scheme")
@@ -105,9 +173,10 @@ func (r *RequestContext) Method() string {
 
 // URL returns the URL of the request
 func (r *RequestContext) URL() *url.URL {
-	if r.url != nil {
+	if r.url != nil && r.urlPath == r.RequestHeader(":path") {
 		return r.url
 	}
+	r.urlPath = r.RequestHeader(":path")
 	r.url, _ = url.Parse(r.RequestHeader(":path"))
 	if r.url == nil {
 		r.url = &url.URL{
@@ -142,9 +211,9 @@ func (r *RequestContext) StatusClass() string {
 
 // Cookies returns a copy of the cookies of the request
 func (r *RequestContext) Cookies() []http.Cookie {
-	if r.cookies == nil && r.RequestHeader("cookie") != "" {
+	if values := r.RequestHeaderValues("cookie"); !slices.Equal(values, r.cookieHeader) {
 		httpreq := http.Request{Header: r.RequestHeaders}
-		r.cookies = httpreq.Cookies()
+		r.cookies, r.cookieHeader = httpreq.Cookies(), slices.Clone(values)
 	}
 
 	cookies := make([]http.Cookie, len(r.cookies))
@@ -166,9 +235,9 @@ func (r *RequestContext) GetCookie(name string) (http.Cookie, bool) {
 
 // SetCookies returns a copy of the cookies from set-cookies response headers
 func (r *RequestContext) SetCookies() []http.Cookie {
-	if r.setCookies == nil && r.ResponseHeader("set-cookie") != "" {
+	if values := r.ResponseHeaderValues("set-cookie"); !slices.Equal(values, r.setCookieHeader) {
 		httpresp := http.Response{Header: r.ResponseHeaders}
-		r.setCookies = httpresp.Cookies()
+		r.setCookies, r.setCookieHeader = httpresp.Cookies(), slices.Clone(values)
 	}
 
 	cookies := make([]http.Cookie, len(r.setCookies))
@@ -216,9 +285,11 @@ func (m *Metadata) Get(key any) any {
 
 func NewRequestContext() *RequestContext {
 	req := &RequestContext{
-		RequestHeaders:  make(http.Header),
-		ResponseHeaders: make(http.Header),
-		startTime:       time.Now(),
+		RequestHeaders:   make(http.Header),
+		ResponseHeaders:  make(http.Header),
+		RequestTrailers:  make(http.Header),
+		ResponseTrailers: make(http.Header),
+		startTime:        time.Now(),
 	}
 
 	return req
@@ -3,11 +3,13 @@ package filter
 import (
 	"net/http"
 	"slices"
+	"strings"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 // routerHeaders requires ClearRouteCache to be set to true
@@ -19,15 +21,23 @@ var routerHeaders = map[string]struct{}{
 }
 
 func isRouterHeader(key string) bool {
-	_, ok := routerHeaders[key]
+	_, ok := routerHeaders[strings.ToLower(key)]
 	return ok
 }
 
 // CommonResponseWriter is a wraper on top of extproc.CommonResponse
 // It provides a fluent API to mutate the request and response headers and body
 type CommonResponseWriter struct {
-	header         http.Header
-	commonResponse *extproc.CommonResponse
+	header          http.Header
+	commonResponse  *extproc.CommonResponse
+	modeOverride    *modeOverride
+	dynamicMetadata *structpb.Struct
+	headerEncoding  HeaderEncoding
+	// removed lists the headers of every RemoveHeaders call, including those already removed.
+	removed []string
+	// original holds the values of the mutated headers before their first mutation, in touched order, for Coalesce.
+	original map[string][]string
+	touched  []string
 }
 
 func NewCommonResponseWriter(headers http.Header) *CommonResponseWriter {
@@ -44,32 +54,52 @@ func NewCommonResponseWriter(headers http.Header) *CommonResponseWriter {
 
 // headerAction sets a header with the given key and value and the given append action
 func (crw *CommonResponseWriter) headerAction(key string, value string, appendAction corev3.HeaderValueOption_HeaderAppendAction) *CommonResponseWriter {
-	var shouldAppend *wrappers.BoolValue
+	crw.touch(key)
 	switch appendAction {
 	case corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD:
-		shouldAppend = &wrappers.BoolValue{Value: true} // FIXME: This is not the documented behavior but it seems to be the only way to append a header.
 		crw.header.Add(key, value)
 	case corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD, corev3.HeaderValueOption_OVERWRITE_IF_EXISTS:
 		crw.header.Set(key, value)
 	}
-	crw.commonResponse.HeaderMutation.SetHeaders = append(crw.commonResponse.HeaderMutation.SetHeaders, &corev3.HeaderValueOption{
-		Header: &corev3.HeaderValue{
-			Key: key,
-			// FIXME: This should be configurable.
-			// https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/ext_proc/v3/external_processor.proto#envoy-v3-api-msg-service-ext-proc-v3-httpheaders
-			// The headers encoding is based on the runtime guard envoy_reloadable_features_send_header_raw_value setting.
-			// When it is true, the header value is encoded in the raw_value field. When it is false, the header value is encoded in the value field.
-			RawValue: []byte(value), // FIXME: This depends on Envoy
-		},
-		AppendAction: appendAction,
-		Append:       shouldAppend,
-	})
+	crw.commonResponse.HeaderMutation.SetHeaders = append(crw.commonResponse.HeaderMutation.SetHeaders, headerValueOption(crw.headerEncoding, key, value, appendAction))
 	if isRouterHeader(key) {
 		crw.ClearRouteCache(true)
 	}
 	return crw
 }
 
+func headerValueOption(encoding HeaderEncoding, key string, value string, appendAction corev3.HeaderValueOption_HeaderAppendAction) *corev3.HeaderValueOption {
+	var shouldAppend *wrappers.BoolValue
+	if appendAction == corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD {
+		shouldAppend = &wrappers.BoolValue{Value: true} // FIXME: This is not the documented behavior but it seems to be the only way to append a header.
+	}
+	return &corev3.HeaderValueOption{
+		Header:       encoding.HeaderValue(key, value),
+		AppendAction: appendAction,
+		Append:       shouldAppend,
+	}
+}
+
+// touch records the values of a header before its first mutation.
+func (crw *CommonResponseWriter) touch(key string) {
+	key = http.CanonicalHeaderKey(key)
+	if _, ok := crw.original[key]; ok {
+		return
+	}
+	if crw.original == nil {
+		crw.original = make(map[string][]string)
+	}
+	crw.original[key] = slices.Clone(crw.header[key])
+	crw.touched = append(crw.touched, key)
+}
+
+// SetHeaderEncoding sets the encoding of the header values, see HeaderEncoding. The service sets the encoding of the
+// stream on the writers it creates.
+func (crw *CommonResponseWriter) SetHeaderEncoding(encoding HeaderEncoding) *CommonResponseWriter {
+	crw.headerEncoding = encoding
+	return crw
+}
+
 // SetHeader sets a header with the given key and value using the OVERWRITE_IF_EXISTS_OR_ADD action
 // This action will overwrite the specified value by discarding any existing values if the header already exists. If the header doesn't exist then this will add the header with specified key and value.
 func (crw *CommonResponseWriter) SetHeader(key string, value string) *CommonResponseWriter {
@@ -85,15 +115,66 @@ func (crw *CommonResponseWriter) AppendHeader(key string, value string) *CommonR
 // RemoveHeaders removes these HTTP headers. Attempts to remove system headers -- any header starting with “:“, plus “host“ -- will be ignored.
 func (crw *CommonResponseWriter) RemoveHeaders(headers ...string) *CommonResponseWriter {
 	for _, h := range headers {
+		crw.removed = append(crw.removed, h)
+		crw.touch(h)
+		crw.header.Del(h)
 		if slices.Contains(crw.commonResponse.HeaderMutation.RemoveHeaders, h) {
 			continue
 		}
 		crw.commonResponse.HeaderMutation.RemoveHeaders = append(crw.commonResponse.HeaderMutation.RemoveHeaders, h)
-		crw.header.Del(h)
 	}
 	return crw
 }
 
+// Coalesce replaces the header mutation, which holds every call made on the writer, with the net mutation of the
+// headers against their values before the first mutation: a header set by several filters is set once, a header set
+// then removed is not sent, and a header only appended to keeps an append. The service calls Coalesce before sending
+// the response to Envoy.
+func (crw *CommonResponseWriter) Coalesce() *CommonResponseWriter {
+	mutation := &extproc.HeaderMutation{}
+	for _, key := range crw.touched {
+		original, values := crw.original[key], crw.header[key]
+		name := strings.ToLower(key)
+		switch {
+		case slices.Equal(original, values):
+		case len(values) == 0:
+			mutation.RemoveHeaders = append(mutation.RemoveHeaders, name)
+		case len(original) > 0 && len(values) > len(original) && slices.Equal(original, values[:len(original)]):
+			for _, v := range values[len(original):] {
+				mutation.SetHeaders = append(mutation.SetHeaders, headerValueOption(crw.headerEncoding, name, v, corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD))
+			}
+		default:
+			mutation.SetHeaders = append(mutation.SetHeaders, headerValueOption(crw.headerEncoding, name, values[0], corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD))
+			for _, v := range values[1:] {
+				mutation.SetHeaders = append(mutation.SetHeaders, headerValueOption(crw.headerEncoding, name, v, corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD))
+			}
+		}
+	}
+	crw.commonResponse.HeaderMutation = mutation
+	return crw
+}
+
+// setHeaderValues replaces the values of a header with the given values, removing the header if there are none.
+func (crw *CommonResponseWriter) setHeaderValues(key string, values []string) *CommonResponseWriter {
+	if slices.Equal(crw.header.Values(key), values) {
+		return crw
+	}
+	if len(values) == 0 {
+		return crw.RemoveHeaders(key)
+	}
+	crw.SetHeader(key, values[0])
+	for _, v := range values[1:] {
+		crw.AppendHeader(key, v)
+	}
+	return crw
+}
+
+// RemovedHeaders returns the headers removed with RemoveHeaders, in call order. Unlike the header mutation, a header
+// removed several times, e.g. by different filters, is listed every time.
+func (crw *CommonResponseWriter) RemovedHeaders() []string {
+	return crw.removed
+}
+
 // SetStatus sets the status of the GRPC response.
 // If set, provide additional direction on how the Envoy proxy should handle the rest of the HTTP filter chain.
 func (crw *CommonResponseWriter) SetStatus(status extproc.CommonResponse_ResponseStatus) *CommonResponseWriter {
@@ -142,14 +223,8 @@ func NewImmediateResponseBuilder() *ImmediateResponseWriter {
 // headerAction sets a header with the given key and value and the given append action
 func (irw *ImmediateResponseWriter) headerAction(key string, value string, appendAction corev3.HeaderValueOption_HeaderAppendAction) *ImmediateResponseWriter {
 	irw.immediateResponse.ImmediateResponse.Headers.SetHeaders = append(irw.immediateResponse.ImmediateResponse.Headers.SetHeaders, &corev3.HeaderValueOption{
-		Header: &corev3.HeaderValue{
-			Key: key,
-			// FIXME: This should be configurable.
-			// https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/ext_proc/v3/external_processor.proto#envoy-v3-api-msg-service-ext-proc-v3-httpheaders
-			// The headers encoding is based on the runtime guard envoy_reloadable_features_send_header_raw_value setting.
-			// When it is true, the header value is encoded in the raw_value field. When it is false, the header value is encoded in the value field.
-			RawValue: []byte(value), // FIXME: This depends on Envoy
-		},
+		// The service re-encodes the headers with the encoding of the stream, see HeaderEncoding.
+		Header:       HeaderEncodingRawValue.HeaderValue(key, value),
 		AppendAction: appendAction,
 	})
 	return irw
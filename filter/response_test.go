@@ -0,0 +1,93 @@
+package filter_test
+
+import (
+	"net/http"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalesce(t *testing.T) {
+	type setHeader struct {
+		key, value string
+		append     bool
+	}
+	for _, tt := range []struct {
+		name       string
+		headers    http.Header
+		mutate     func(crw *filter.CommonResponseWriter)
+		setHeaders []setHeader
+		remove     []string
+	}{{
+		name: "set by several filters",
+		mutate: func(crw *filter.CommonResponseWriter) {
+			crw.SetHeader("x-variant", "a")
+			crw.SetHeader("X-Variant", "b")
+			crw.SetHeader("x-variant", "c")
+		},
+		setHeaders: []setHeader{{key: "x-variant", value: "c"}},
+	}, {
+		name:    "set then removed",
+		headers: http.Header{"X-Debug": {"1"}},
+		mutate: func(crw *filter.CommonResponseWriter) {
+			crw.SetHeader("x-variant", "a")
+			crw.RemoveHeaders("x-variant", "x-debug")
+		},
+		remove: []string{"x-debug"},
+	}, {
+		name:    "removed then set to the original value",
+		headers: http.Header{"X-Debug": {"1"}},
+		mutate: func(crw *filter.CommonResponseWriter) {
+			crw.RemoveHeaders("x-debug")
+			crw.SetHeader("x-debug", "1")
+		},
+	}, {
+		name:    "appended",
+		headers: http.Header{"Vary": {"accept"}},
+		mutate: func(crw *filter.CommonResponseWriter) {
+			crw.AppendHeader("vary", "cookie")
+			crw.AppendHeader("vary", "origin")
+		},
+		setHeaders: []setHeader{{key: "vary", value: "cookie", append: true}, {key: "vary", value: "origin", append: true}},
+	}, {
+		name:    "set then appended",
+		headers: http.Header{"Vary": {"accept"}},
+		mutate: func(crw *filter.CommonResponseWriter) {
+			crw.SetHeader("vary", "cookie")
+			crw.AppendHeader("vary", "origin")
+		},
+		setHeaders: []setHeader{{key: "vary", value: "cookie"}, {key: "vary", value: "origin", append: true}},
+	}, {
+		name:    "removed twice",
+		headers: http.Header{"X-Debug": {"1"}},
+		mutate: func(crw *filter.CommonResponseWriter) {
+			crw.RemoveHeaders("x-debug")
+			crw.SetHeader("x-debug", "2")
+			crw.RemoveHeaders("x-debug")
+		},
+		remove: []string{"x-debug"},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := tt.headers
+			if headers == nil {
+				headers = http.Header{}
+			}
+			crw := filter.NewCommonResponseWriter(headers)
+			tt.mutate(crw)
+			mutation := crw.Coalesce().CommonResponse().GetHeaderMutation()
+
+			var got []setHeader
+			for _, h := range mutation.GetSetHeaders() {
+				got = append(got, setHeader{
+					key:    h.GetHeader().GetKey(),
+					value:  string(h.GetHeader().GetRawValue()),
+					append: h.GetAppendAction() == corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD,
+				})
+			}
+			require.Equal(t, tt.setHeaders, got)
+			require.Equal(t, tt.remove, mutation.GetRemoveHeaders())
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The rewrite helpers mutate the :path, :authority and :method request headers, which the writer clears the route
+// cache for. RequestContext.URL, Authority and Method return the rewritten values to the next filters.
+
+// splitPath returns the path and the raw query of the :path header.
+func (crw *CommonResponseWriter) splitPath() (string, string) {
+	path, query, _ := strings.Cut(crw.header.Get(":path"), "?")
+	return path, query
+}
+
+func (crw *CommonResponseWriter) setPath(path string, query string) *CommonResponseWriter {
+	if query != "" {
+		path += "?" + query
+	}
+	return crw.SetHeader(":path", path)
+}
+
+// SetPath rewrites the path of the request, keeping its query. The path is escaped, e.g. "/a b" is sent as "/a%20b".
+func (crw *CommonResponseWriter) SetPath(path string) *CommonResponseWriter {
+	_, query := crw.splitPath()
+	return crw.setPath((&url.URL{Path: path}).EscapedPath(), query)
+}
+
+// RewritePath replaces the matches of re in the escaped path of the request with replacement, which can reference the
+// capture groups of re, e.g. RewritePath(regexp.MustCompile(`^/v1/(.*)$`), "/api/$1"). The query is kept.
+func (crw *CommonResponseWriter) RewritePath(re *regexp.Regexp, replacement string) *CommonResponseWriter {
+	path, query := crw.splitPath()
+	if !re.MatchString(path) {
+		return crw
+	}
+	return crw.setPath(re.ReplaceAllString(path, replacement), query)
+}
+
+// SetQuery replaces the query of the request with the encoded values, sorted by key.
+func (crw *CommonResponseWriter) SetQuery(values url.Values) *CommonResponseWriter {
+	path, _ := crw.splitPath()
+	return crw.setPath(path, values.Encode())
+}
+
+// SetQueryParam sets the query parameter key to value, replacing its existing values. The other parameters are kept
+// as they are, in their order and encoding.
+func (crw *CommonResponseWriter) SetQueryParam(key string, value string) *CommonResponseWriter {
+	path, query := crw.splitPath()
+	param := url.QueryEscape(key) + "=" + url.QueryEscape(value)
+	var params []string
+	set := false
+	for _, p := range queryParams(query) {
+		if queryParamKey(p) != key {
+			params = append(params, p)
+			continue
+		}
+		if !set {
+			params = append(params, param)
+			set = true
+		}
+	}
+	if !set {
+		params = append(params, param)
+	}
+	return crw.setPath(path, strings.Join(params, "&"))
+}
+
+// AddQueryParam adds the value to the query parameter key.
+func (crw *CommonResponseWriter) AddQueryParam(key string, value string) *CommonResponseWriter {
+	path, query := crw.splitPath()
+	params := append(queryParams(query), url.QueryEscape(key)+"="+url.QueryEscape(value))
+	return crw.setPath(path, strings.Join(params, "&"))
+}
+
+// DelQueryParam removes the values of the query parameter key.
+func (crw *CommonResponseWriter) DelQueryParam(key string) *CommonResponseWriter {
+	path, query := crw.splitPath()
+	var params []string
+	for _, p := range queryParams(query) {
+		if queryParamKey(p) != key {
+			params = append(params, p)
+		}
+	}
+	if len(params) == len(queryParams(query)) {
+		return crw
+	}
+	return crw.setPath(path, strings.Join(params, "&"))
+}
+
+// queryParams returns the raw key=value parameters of a raw query.
+func queryParams(query string) []string {
+	var params []string
+	for p := range strings.SplitSeq(query, "&") {
+		if p != "" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// queryParamKey returns the unescaped key of a raw query parameter.
+func queryParamKey(param string) string {
+	key, _, _ := strings.Cut(param, "=")
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
+
+// SetAuthority rewrites the authority of the request, the host header of HTTP/1 requests.
+func (crw *CommonResponseWriter) SetAuthority(authority string) *CommonResponseWriter {
+	return crw.SetHeader(":authority", authority)
+}
+
+// SetMethod rewrites the method of the request.
+func (crw *CommonResponseWriter) SetMethod(method string) *CommonResponseWriter {
+	return crw.SetHeader(":method", method)
+}
@@ -0,0 +1,93 @@
+package filter_test
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrite(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		path     string
+		rewrite  func(crw *filter.CommonResponseWriter)
+		wantPath string
+	}{{
+		name:     "set path keeps the query",
+		path:     "/search?q=a%20b&page=2",
+		rewrite:  func(crw *filter.CommonResponseWriter) { crw.SetPath("/find all") },
+		wantPath: "/find%20all?q=a%20b&page=2",
+	}, {
+		name: "regex capture",
+		path: "/v1/users/42?fields=name",
+		rewrite: func(crw *filter.CommonResponseWriter) {
+			crw.RewritePath(regexp.MustCompile(`^/v1/users/(\d+)$`), "/api/users/$1")
+		},
+		wantPath: "/api/users/42?fields=name",
+	}, {
+		name:     "regex without match",
+		path:     "/v2/users/42",
+		rewrite:  func(crw *filter.CommonResponseWriter) { crw.RewritePath(regexp.MustCompile(`^/v1/(.*)$`), "/api/$1") },
+		wantPath: "/v2/users/42",
+	}, {
+		name:     "set query",
+		path:     "/search?q=a",
+		rewrite:  func(crw *filter.CommonResponseWriter) { crw.SetQuery(url.Values{"q": {"a b"}, "lang": {"en"}}) },
+		wantPath: "/search?lang=en&q=a+b",
+	}, {
+		name:     "set query param keeps the other parameters",
+		path:     "/search?q=a%20b&page=2&page=3&z",
+		rewrite:  func(crw *filter.CommonResponseWriter) { crw.SetQueryParam("page", "1&2") },
+		wantPath: "/search?q=a%20b&page=1%262&z",
+	}, {
+		name:     "add query param",
+		path:     "/search",
+		rewrite:  func(crw *filter.CommonResponseWriter) { crw.AddQueryParam("utm source", "mail") },
+		wantPath: "/search?utm+source=mail",
+	}, {
+		name: "delete query params",
+		path: "/search?q=a&utm_source=mail&utm_source=web",
+		rewrite: func(crw *filter.CommonResponseWriter) {
+			crw.DelQueryParam("utm_source").DelQueryParam("q")
+		},
+		wantPath: "/search",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := filter.NewRequestContext()
+			req.RequestHeaders.Set(":path", tt.path)
+			require.NotNil(t, req.URL())
+
+			crw := filter.NewCommonResponseWriter(req.RequestHeaders)
+			tt.rewrite(crw)
+			require.Equal(t, tt.wantPath, req.RequestHeader(":path"))
+			require.Equal(t, tt.wantPath, req.URL().String())
+			require.Equal(t, tt.path != tt.wantPath, crw.CommonResponse().GetClearRouteCache())
+		})
+	}
+
+	t.Run("authority and method", func(t *testing.T) {
+		req := filter.NewRequestContext()
+		req.RequestHeaders.Set(":authority", "example.com")
+		req.RequestHeaders.Set(":method", "HEAD")
+
+		crw := filter.NewCommonResponseWriter(req.RequestHeaders)
+		crw.SetAuthority("backend.internal").SetMethod("GET")
+		require.Equal(t, "backend.internal", req.Authority())
+		require.Equal(t, "GET", req.Method())
+		require.True(t, crw.CommonResponse().GetClearRouteCache())
+	})
+
+	t.Run("router headers are case insensitive", func(t *testing.T) {
+		crw := filter.NewCommonResponseWriter(http.Header{})
+		crw.SetHeader("Host", "backend.internal")
+		require.True(t, crw.CommonResponse().GetClearRouteCache())
+
+		crw = filter.NewCommonResponseWriter(http.Header{})
+		crw.SetHeader(":Authority", "backend.internal")
+		require.True(t, crw.CommonResponse().GetClearRouteCache())
+	})
+}
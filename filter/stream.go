@@ -1,7 +1,69 @@
 package filter
 
+import (
+	"time"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
 type Stream interface {
 	// OnStreamComplete runs when a Stream ends, which can happen at any point in the protocol lifecycle (e.g due to an
 	// ImmediateResponse being returned).
 	OnStreamComplete(req *RequestContext) error
 }
+
+// StreamResultCallback is implemented by filters notified when a stream ends, like Stream, with the result describing
+// how the stream went.
+type StreamResultCallback interface {
+	OnStreamResult(req *RequestContext, result *StreamResult) error
+}
+
+// StreamStartCallback is implemented by filters notified when a stream starts, before the first message is received.
+// The request is still empty, so the conditions of When are not evaluated: every wrapped filter is notified.
+type StreamStartCallback interface {
+	OnStreamStart(req *RequestContext)
+}
+
+// StreamMessageCallback is implemented by filters notified of every message of the stream, e.g. "RequestHeaders",
+// after the filters processed it.
+type StreamMessageCallback interface {
+	OnStreamMessage(req *RequestContext, stage string)
+}
+
+// ImmediateResponseCallback is implemented by filters notified when an immediate response is sent to Envoy.
+type ImmediateResponseCallback interface {
+	OnImmediateResponse(req *RequestContext, resp *extproc.ImmediateResponse)
+}
+
+// StreamErrorCallback is implemented by filters notified when the stream is aborted by an error,
+// e.g. an error of a filter with the FailClosed policy. Envoy then fails the request or skips the processor.
+type StreamErrorCallback interface {
+	OnStreamError(req *RequestContext, err error)
+}
+
+// StreamCancelCallback is implemented by filters notified when Envoy cancels the stream, e.g. when the client goes away.
+type StreamCancelCallback interface {
+	OnStreamCancel(req *RequestContext)
+}
+
+// StreamResult describes how a stream went, see StreamResultCallback.
+type StreamResult struct {
+	// Stages lists the messages processed, in order, e.g. ["RequestHeaders", "ResponseHeaders"].
+	Stages []string
+	// ImmediateResponse is the immediate response sent to Envoy, returned by ShortCircuitFilter at ShortCircuitStage.
+	// It is nil if the stream was not short-circuited.
+	ImmediateResponse  *extproc.ImmediateResponse
+	ShortCircuitFilter Filter
+	ShortCircuitStage  string
+	// Err is the error aborting the stream, nil if it ended normally.
+	Err error
+	// Canceled reports whether Envoy canceled the stream, e.g. when the client went away.
+	Canceled bool
+	// Duration is the duration of the stream.
+	Duration time.Duration
+}
+
+// ShortCircuited reports whether an immediate response was sent to Envoy.
+func (r *StreamResult) ShortCircuited() bool {
+	return r.ImmediateResponse != nil
+}
@@ -0,0 +1,97 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+)
+
+// RemoveHeaderValue removes the values of a header equal to value, keeping its other values.
+func (crw *CommonResponseWriter) RemoveHeaderValue(key string, value string) *CommonResponseWriter {
+	values := slices.DeleteFunc(slices.Clone(crw.header.Values(key)), func(v string) bool {
+		return v == value
+	})
+	return crw.setHeaderValues(key, values)
+}
+
+// ReplaceHeaderValues replaces the values of a header matching the predicate with value, keeping its other values.
+func (crw *CommonResponseWriter) ReplaceHeaderValues(key string, match func(value string) bool, value string) *CommonResponseWriter {
+	values := slices.Clone(crw.header.Values(key))
+	for i, v := range values {
+		if match(v) {
+			values[i] = value
+		}
+	}
+	return crw.setHeaderValues(key, values)
+}
+
+// SetHeaderDirective sets a directive of a header holding a comma-separated list, e.g.
+// SetHeaderDirective("cache-control", "max-age", "60") or SetHeaderDirective("vary", "accept-encoding", "").
+// The directive is added if the header doesn't have it, and the values of the header are joined in one value.
+// Directive names are case insensitive.
+func (crw *CommonResponseWriter) SetHeaderDirective(key string, name string, value string) *CommonResponseWriter {
+	directive := name
+	if value != "" {
+		directive += "=" + value
+	}
+	var directives []string
+	set := false
+	for _, d := range headerDirectives(crw.header.Values(key)) {
+		if !strings.EqualFold(directiveName(d), name) {
+			directives = append(directives, d)
+			continue
+		}
+		if !set {
+			directives = append(directives, directive)
+			set = true
+		}
+	}
+	if !set {
+		directives = append(directives, directive)
+	}
+	return crw.setHeaderValues(key, []string{strings.Join(directives, ", ")})
+}
+
+// RemoveHeaderDirective removes a directive of a header holding a comma-separated list, e.g.
+// RemoveHeaderDirective("cache-control", "no-store"). The header is removed when it has no directives left.
+func (crw *CommonResponseWriter) RemoveHeaderDirective(key string, name string) *CommonResponseWriter {
+	directives := headerDirectives(crw.header.Values(key))
+	remaining := slices.DeleteFunc(slices.Clone(directives), func(d string) bool {
+		return strings.EqualFold(directiveName(d), name)
+	})
+	if len(remaining) == len(directives) {
+		return crw
+	}
+	if len(remaining) == 0 {
+		return crw.setHeaderValues(key, nil)
+	}
+	return crw.setHeaderValues(key, []string{strings.Join(remaining, ", ")})
+}
+
+// headerDirectives returns the comma-separated directives of the header values. Commas in quoted strings, e.g.
+// no-cache="set-cookie, set-cookie2", don't separate directives.
+func headerDirectives(values []string) []string {
+	var directives []string
+	for _, v := range values {
+		start, quoted := 0, false
+		for i := 0; i <= len(v); i++ {
+			switch {
+			case i < len(v) && v[i] == '\\' && quoted:
+				i++
+			case i < len(v) && v[i] == '"':
+				quoted = !quoted
+			case i == len(v) || v[i] == ',' && !quoted:
+				if d := strings.TrimSpace(v[start:i]); d != "" {
+					directives = append(directives, d)
+				}
+				start = i + 1
+			}
+		}
+	}
+	return directives
+}
+
+// directiveName returns the name of a name=value directive.
+func directiveName(directive string) string {
+	name, _, _ := strings.Cut(directive, "=")
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,79 @@
+package filter_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderValues(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+		mutate func(crw *filter.CommonResponseWriter)
+		want   []string
+	}{{
+		name:   "remove value",
+		header: http.Header{"Link": {"</a.css>; rel=preload", "</b.js>; rel=preload"}},
+		mutate: func(crw *filter.CommonResponseWriter) { crw.RemoveHeaderValue("link", "</a.css>; rel=preload") },
+		want:   []string{"</b.js>; rel=preload"},
+	}, {
+		name:   "remove last value",
+		header: http.Header{"Vary": {"cookie"}},
+		mutate: func(crw *filter.CommonResponseWriter) { crw.RemoveHeaderValue("vary", "cookie") },
+	}, {
+		name:   "replace values",
+		header: http.Header{"Link": {"</a.css>; rel=preload", "</b.js>; rel=preload"}},
+		mutate: func(crw *filter.CommonResponseWriter) {
+			crw.ReplaceHeaderValues("link", func(v string) bool { return strings.HasPrefix(v, "</b.js>") }, "</c.js>; rel=preload")
+		},
+		want: []string{"</a.css>; rel=preload", "</c.js>; rel=preload"},
+	}, {
+		name:   "set directive",
+		header: http.Header{"Cache-Control": {"public, Max-Age=60", "no-transform"}},
+		mutate: func(crw *filter.CommonResponseWriter) { crw.SetHeaderDirective("cache-control", "max-age", "300") },
+		want:   []string{"public, max-age=300, no-transform"},
+	}, {
+		name:   "add directive",
+		header: http.Header{"Vary": {"accept"}},
+		mutate: func(crw *filter.CommonResponseWriter) { crw.SetHeaderDirective("vary", "accept-encoding", "") },
+		want:   []string{"accept, accept-encoding"},
+	}, {
+		name:   "add directive to a missing header",
+		mutate: func(crw *filter.CommonResponseWriter) { crw.SetHeaderDirective("cache-control", "no-store", "") },
+		want:   []string{"no-store"},
+	}, {
+		name:   "remove directive with a quoted value",
+		header: http.Header{"Cache-Control": {`private, no-cache="set-cookie, x-user", max-age=0`}},
+		mutate: func(crw *filter.CommonResponseWriter) { crw.RemoveHeaderDirective("cache-control", "no-cache") },
+		want:   []string{"private, max-age=0"},
+	}, {
+		name:   "remove last directive",
+		header: http.Header{"Cache-Control": {"no-store"}},
+		mutate: func(crw *filter.CommonResponseWriter) { crw.RemoveHeaderDirective("cache-control", "no-store") },
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := filter.NewRequestContext()
+			for k, v := range tt.header {
+				req.ResponseHeaders[k] = v
+			}
+			crw := filter.NewCommonResponseWriter(req.ResponseHeaders)
+			tt.mutate(crw)
+			key := "cache-control"
+			for k := range tt.header {
+				key = k
+			}
+			require.Equal(t, tt.want, req.ResponseHeaderValues(key))
+		})
+	}
+
+	t.Run("unchanged headers are not mutated", func(t *testing.T) {
+		crw := filter.NewCommonResponseWriter(http.Header{"Cache-Control": {"public"}})
+		crw.RemoveHeaderDirective("cache-control", "no-store").RemoveHeaderValue("cache-control", "private")
+		require.Empty(t, crw.CommonResponse().GetHeaderMutation().GetSetHeaders())
+		require.Empty(t, crw.CommonResponse().GetHeaderMutation().GetRemoveHeaders())
+	})
+}
@@ -0,0 +1,36 @@
+// Package metrics exports the metrics of the service in the Prometheus format, see server.WithMetrics.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// Prometheus is an OpenTelemetry meter provider whose metrics are served in the Prometheus format by Handler.
+type Prometheus struct {
+	*sdkmetric.MeterProvider
+	registry *prometheus.Registry
+}
+
+// NewPrometheus returns a meter provider exporting its metrics to a new Prometheus registry.
+func NewPrometheus() (*Prometheus, error) {
+	registry := prometheus.NewRegistry()
+	exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create prometheus exporter: %w", err)
+	}
+	return &Prometheus{
+		MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)),
+		registry:      registry,
+	}, nil
+}
+
+// Handler serves the metrics in the Prometheus format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
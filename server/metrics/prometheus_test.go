@@ -0,0 +1,29 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getyourguide/extproc-go/server/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	prom, err := metrics.NewPrometheus()
+	require.NoError(t, err)
+	defer prom.Shutdown(context.Background()) // nolint:errcheck
+
+	counter, err := prom.Meter("test").Int64Counter("extproc.streams")
+	require.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	rec := httptest.NewRecorder()
+	prom.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "# TYPE extproc_streams_total counter")
+}
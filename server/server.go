@@ -1,6 +1,7 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
@@ -13,6 +14,7 @@ import (
 	"github.com/getyourguide/extproc-go/filter"
 	"github.com/getyourguide/extproc-go/service"
 	"github.com/getyourguide/extproc-go/test/echo"
+	"go.opentelemetry.io/otel/metric"
 	"google.golang.org/grpc"
 )
 
@@ -20,7 +22,10 @@ const (
 	defaultGrpcNetwork  = "tcp"
 	defaultGrpcAddress  = ":8081"
 	defaultHTTPBindAddr = ":8080"
+	defaultMetricsAddr  = ":9090"
 	defaultShutdownWait = 5 * time.Second
+
+	meterName = "github.com/getyourguide/extproc-go"
 )
 
 type Server struct {
@@ -29,6 +34,7 @@ type Server struct {
 	grpcNetwork string
 	grpcAddress string
 	echoConfig  echoConfig
+	metrics     metricsConfig
 	ctx         context.Context
 }
 
@@ -39,6 +45,13 @@ type echoConfig struct {
 	httpsrv     *http.Server
 }
 
+type metricsConfig struct {
+	bindAddress string
+	provider    metric.MeterProvider
+	handler     http.Handler
+	httpsrv     *http.Server
+}
+
 type Option func(*Server)
 
 func New(ctx context.Context, opts ...Option) *Server {
@@ -76,6 +89,19 @@ func New(ctx context.Context, opts ...Option) *Server {
 		srv.echoConfig.httpsrv.Handler = srv.echoConfig.mux
 
 	}
+
+	if srv.metrics.provider != nil {
+		// The meter is prepended so an explicit service.WithMeter still takes precedence.
+		srv.serviceOpts = append([]service.Option{service.WithMeter(srv.metrics.provider.Meter(meterName))}, srv.serviceOpts...)
+	}
+	if srv.metrics.handler != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", srv.metrics.handler)
+		srv.metrics.httpsrv = &http.Server{
+			Addr:    srv.metrics.bindAddress,
+			Handler: mux,
+		}
+	}
 	return srv
 }
 
@@ -115,12 +141,32 @@ func WithEchoServerMux(mux *http.ServeMux, address string) Option {
 	}
 }
 
+// WithMetrics records the metrics of the service with the meter provider and serves handler on address at /metrics.
+// The provider is shut down with the server if it has a Shutdown method. The metrics package provides a Prometheus
+// exporter, e.g.
+//
+//	prom, err := metrics.NewPrometheus()
+//	server.New(ctx, server.WithMetrics(":9090", prom, prom.Handler()))
+func WithMetrics(address string, provider metric.MeterProvider, handler http.Handler) Option {
+	return func(s *Server) {
+		s.metrics.bindAddress = cmp.Or(address, defaultMetricsAddr)
+		s.metrics.provider = provider
+		s.metrics.handler = handler
+	}
+}
+
 func (s *Server) Serve() error {
 	if s.ctx == nil {
 		s.ctx = context.TODO()
 	}
 
 	errCh := make(chan error, 1)
+	if s.metrics.httpsrv != nil {
+		go func() {
+			slog.Info("starting metrics server", "address", s.metrics.bindAddress)
+			errCh <- s.metrics.httpsrv.ListenAndServe()
+		}()
+	}
 	if s.echoConfig.enabled {
 		go func() {
 			slog.Info("starting http server", "address", s.echoConfig.bindAddress)
@@ -168,6 +214,17 @@ func (s *Server) Stop() error {
 			return fmt.Errorf("http server shutdown error: %w", err)
 		}
 	}
+	if s.metrics.httpsrv != nil {
+		slog.Info("stopping metrics server")
+		if err := s.metrics.httpsrv.Shutdown(ctx); err != nil {
+			return fmt.Errorf("metrics server shutdown error: %w", err)
+		}
+	}
+	if provider, ok := s.metrics.provider.(interface{ Shutdown(context.Context) error }); ok {
+		if err := provider.Shutdown(ctx); err != nil {
+			return fmt.Errorf("meter provider shutdown error: %w", err)
+		}
+	}
 	time.Sleep(defaultShutdownWait)
 	return nil
 }
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getyourguide/extproc-go/filter"
+)
+
+// accessLogOperator matches the command operators of a format, e.g. %REQ(:path)% or %REQ(user-agent):20%.
+var accessLogOperator = regexp.MustCompile(`%([A-Z_]+)(?:\(([^)]*)\))?(?::(\d+))?%`)
+
+// AccessLogFormat formats the access log of a stream, see ParseAccessLogFormat and ParseAccessLogJSONFormat.
+type AccessLogFormat struct {
+	text   []accessLogSegment
+	fields []accessLogField
+}
+
+// accessLogSegment is either a literal or a command operator of a format.
+type accessLogSegment struct {
+	literal  string
+	operator func(s *stream) any
+}
+
+type accessLogField struct {
+	key      string
+	segments []accessLogSegment
+}
+
+// ParseAccessLogFormat parses an Envoy-style text format, e.g. "%REQ(:method)% %REQ(:path)% %RESPONSE_CODE% %DURATION%".
+// The supported command operators are:
+//
+//   - %REQ(X?Y):Z%: the request header X, or Y if X is not set, truncated to Z characters
+//   - %RESP(X?Y):Z%: the response header X, or Y if X is not set
+//   - %TRAILER(X?Y):Z%: the response trailer X, or Y if X is not set
+//   - %START_TIME%: the start of the stream
+//   - %DURATION%: the duration of the stream in milliseconds
+//   - %RESPONSE_CODE%: the status of the immediate response, or else of the response headers
+//   - %IMMEDIATE_RESPONSE%: the immediate response sent by a filter as "stage:filter:status"
+//   - %MUTATIONS%: the mutations made by the filters as "stage:filter:action:header"
+//   - %METADATA(X)%: the value of the filter.Key named X in the request metadata
+//
+// Missing values are rendered as "-".
+func ParseAccessLogFormat(format string) (*AccessLogFormat, error) {
+	segments, err := parseAccessLogSegments(format)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLogFormat{text: segments}, nil
+}
+
+// ParseAccessLogJSONFormat parses Envoy-style JSON fields, each value being a format as in ParseAccessLogFormat, e.g.
+// {"path": "%REQ(:path)%", "status": "%RESPONSE_CODE%"}. A field made of a single command operator keeps the type of
+// its value, e.g. a number for %DURATION% or a list for %MUTATIONS%, and missing values are null.
+// The fields are logged as key and values, in key order.
+func ParseAccessLogJSONFormat(fields map[string]string) (*AccessLogFormat, error) {
+	f := &AccessLogFormat{}
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		segments, err := parseAccessLogSegments(fields[key])
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+		f.fields = append(f.fields, accessLogField{key: key, segments: segments})
+	}
+	return f, nil
+}
+
+func parseAccessLogSegments(format string) ([]accessLogSegment, error) {
+	var segments []accessLogSegment
+	last := 0
+	for _, m := range accessLogOperator.FindAllStringSubmatchIndex(format, -1) {
+		if m[0] > last {
+			segments = append(segments, accessLogSegment{literal: format[last:m[0]]})
+		}
+		name := format[m[2]:m[3]]
+		var arg string
+		if m[4] >= 0 {
+			arg = format[m[4]:m[5]]
+		}
+		maxLength := 0
+		if m[6] >= 0 {
+			maxLength, _ = strconv.Atoi(format[m[6]:m[7]])
+		}
+		operator, err := accessLogOperatorFunc(name, arg)
+		if err != nil {
+			return nil, err
+		}
+		if maxLength > 0 {
+			operator = truncate(operator, maxLength)
+		}
+		segments = append(segments, accessLogSegment{operator: operator})
+		last = m[1]
+	}
+	if last < len(format) {
+		segments = append(segments, accessLogSegment{literal: format[last:]})
+	}
+	return segments, nil
+}
+
+func accessLogOperatorFunc(name string, arg string) (func(s *stream) any, error) {
+	header := func(get func(req *filter.RequestContext, key string) string) (func(s *stream) any, error) {
+		if arg == "" {
+			return nil, fmt.Errorf("%%%s%% requires a header name", name)
+		}
+		main, alt, _ := strings.Cut(arg, "?")
+		return func(s *stream) any {
+			for _, key := range []string{main, alt} {
+				if key == "" {
+					continue
+				}
+				if v := get(s.req, key); v != "" {
+					return v
+				}
+			}
+			return nil
+		}, nil
+	}
+
+	switch name {
+	case "REQ":
+		return header((*filter.RequestContext).RequestHeader)
+	case "RESP":
+		return header((*filter.RequestContext).ResponseHeader)
+	case "TRAILER":
+		return header((*filter.RequestContext).ResponseTrailer)
+	case "START_TIME":
+		return func(s *stream) any {
+			return s.start.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+		}, nil
+	case "DURATION":
+		return func(s *stream) any {
+			return time.Since(s.start).Milliseconds()
+		}, nil
+	case "RESPONSE_CODE":
+		return func(s *stream) any {
+			if s.result.ImmediateResponse != nil {
+				return int(s.result.ImmediateResponse.GetStatus().GetCode())
+			}
+			if status := s.req.Status(); status != 0 {
+				return status
+			}
+			return nil
+		}, nil
+	case "IMMEDIATE_RESPONSE":
+		return func(s *stream) any {
+			r := s.result
+			if r.ImmediateResponse == nil {
+				return nil
+			}
+			return fmt.Sprintf("%s:%s:%d", r.ShortCircuitStage, filterName(r.ShortCircuitFilter), r.ImmediateResponse.GetStatus().GetCode())
+		}, nil
+	case "METADATA":
+		if arg == "" {
+			return nil, fmt.Errorf("%%%s%% requires a key name", name)
+		}
+		return func(s *stream) any {
+			return s.req.Metadata().Values()[arg]
+		}, nil
+	case "MUTATIONS":
+		return func(s *stream) any {
+			audit := s.req.Mutations()
+			if len(audit) == 0 {
+				return nil
+			}
+			mutations := make([]string, 0, len(audit))
+			for _, m := range audit {
+				mutations = append(mutations, m.String())
+			}
+			return mutations
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown command operator %%%s%%", name)
+}
+
+// truncate limits the string values of operator to maxLength bytes.
+func truncate(operator func(s *stream) any, maxLength int) func(s *stream) any {
+	return func(s *stream) any {
+		v := operator(s)
+		if str, ok := v.(string); ok && len(str) > maxLength {
+			return str[:maxLength]
+		}
+		return v
+	}
+}
+
+func renderAccessLogSegments(segments []accessLogSegment, s *stream) string {
+	var b strings.Builder
+	for _, seg := range segments {
+		if seg.operator == nil {
+			b.WriteString(seg.literal)
+			continue
+		}
+		switch v := seg.operator(s).(type) {
+		case nil:
+			b.WriteString("-")
+		case []string:
+			b.WriteString(strings.Join(v, ","))
+		default:
+			fmt.Fprint(&b, v)
+		}
+	}
+	return b.String()
+}
+
+// writeAccessLog emits the access log of a stream through the logger of the service.
+func (svc *ExtProcessor) writeAccessLog(s *stream) {
+	log := svc.log.WithName("accesslog")
+	if svc.accessLog.text != nil {
+		log.Info(renderAccessLogSegments(svc.accessLog.text, s))
+		return
+	}
+	keysAndValues := make([]any, 0, 2*len(svc.accessLog.fields))
+	for _, field := range svc.accessLog.fields {
+		var value any
+		if len(field.segments) == 1 && field.segments[0].operator != nil {
+			value = field.segments[0].operator(s)
+		} else {
+			value = renderAccessLogSegments(field.segments, s)
+		}
+		keysAndValues = append(keysAndValues, field.key, value)
+	}
+	for _, key := range svc.accessLogKeys {
+		value, _ := key.Value(s.req)
+		keysAndValues = append(keysAndValues, key.Name(), value)
+	}
+	log.Info("access log", keysAndValues...)
+}
+
+// sampleAccessLog reports whether the access log of a new stream is written.
+func (svc *ExtProcessor) sampleAccessLog() bool {
+	return svc.accessLog != nil && (svc.accessLogSampleRate >= 1 || rand.Float64() < svc.accessLogSampleRate)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	requests := []*extproc.ProcessingRequest{{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{
+			Headers: &corev3.HeaderMap{Headers: []*corev3.HeaderValue{
+				{Key: ":method", RawValue: []byte(http.MethodGet)},
+				{Key: ":path", RawValue: []byte("/users")},
+			}},
+		}},
+	}, {
+		Request: &extproc.ProcessingRequest_ResponseBody{ResponseBody: &extproc.HttpBody{Body: []byte("deny"), EndOfStream: true}},
+	}}
+	process := func(t *testing.T, options ...Option) []string {
+		var lines []string
+		log := funcr.New(func(prefix, args string) {
+			lines = append(lines, prefix+" "+args)
+		}, funcr.Options{})
+		options = append(options, WithLogger(log), WithFilters(&setHeaderFilter{}, &bodyFilter{}))
+		require.NoError(t, New(options...).Process(newFakeProcessServer(requests...)))
+		return lines
+	}
+
+	t.Run("text format", func(t *testing.T) {
+		format, err := ParseAccessLogFormat("%REQ(:method)% %REQ(:path):4% %REQ(x-missing?:path)% %RESP(server)% %RESPONSE_CODE% %IMMEDIATE_RESPONSE% %MUTATIONS%")
+		require.NoError(t, err)
+		lines := process(t, WithAccessLog(format))
+		require.Equal(t, []string{
+			`accesslog "level"=0 "msg"="GET /use /users - 403 ResponseBody:service.bodyFilter:403 RequestHeaders:service.setHeaderFilter:set:x-next"`,
+		}, lines)
+	})
+
+	t.Run("json format", func(t *testing.T) {
+		format, err := ParseAccessLogJSONFormat(map[string]string{
+			"path":      "%REQ(:path)%",
+			"status":    "%RESPONSE_CODE%",
+			"mutations": "%MUTATIONS%",
+			"upstream":  "%RESP(server)%",
+			"request":   "%REQ(:method)% %REQ(:path)%",
+		})
+		require.NoError(t, err)
+		lines := process(t, WithAccessLog(format))
+		require.Equal(t, []string{
+			`accesslog "level"=0 "msg"="access log" "mutations"=["RequestHeaders:service.setHeaderFilter:set:x-next"] "path"="/users" "request"="GET /users" "status"=403 "upstream"=null`,
+		}, lines)
+	})
+
+	t.Run("sampling", func(t *testing.T) {
+		format, err := ParseAccessLogFormat("%REQ(:path)%")
+		require.NoError(t, err)
+		require.Empty(t, process(t, WithAccessLog(format), WithAccessLogSampleRate(0)))
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := ParseAccessLogFormat("%UNKNOWN%")
+		require.ErrorContains(t, err, "unknown command operator %UNKNOWN%")
+		_, err = ParseAccessLogJSONFormat(map[string]string{"path": "%REQ%"})
+		require.ErrorContains(t, err, `field "path": %REQ% requires a header name`)
+	})
+}
@@ -3,8 +3,11 @@ package service
 import (
 	"testing"
 
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	"github.com/getyourguide/extproc-go/filter"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
@@ -44,3 +47,47 @@ func TestMergeAttributesIntoReq(t *testing.T) {
 		require.False(t, ok)
 	})
 }
+
+func TestMergeMetadataContextIntoReq(t *testing.T) {
+	t.Run("merges filter metadata per namespace across messages", func(t *testing.T) {
+		req := filter.NewRequestContext()
+		mergeMetadataContextIntoReq(req, &corev3.Metadata{
+			FilterMetadata: map[string]*structpb.Struct{
+				"gyg.route": {Fields: map[string]*structpb.Value{"cache": structpb.NewBoolValue(true)}},
+			},
+		})
+		mergeMetadataContextIntoReq(req, &corev3.Metadata{
+			FilterMetadata: map[string]*structpb.Struct{
+				"gyg.route": {Fields: map[string]*structpb.Value{"team": structpb.NewStringValue("edge")}},
+			},
+		})
+
+		cache, ok := req.MetadataContextValue("gyg.route", "cache")
+		require.True(t, ok)
+		require.True(t, cache.GetBoolValue())
+
+		team, ok := req.MetadataContextValue("gyg.route", "team")
+		require.True(t, ok)
+		require.Equal(t, "edge", team.GetStringValue())
+	})
+
+	t.Run("nil metadata is a no-op", func(t *testing.T) {
+		req := filter.NewRequestContext()
+		mergeMetadataContextIntoReq(req, nil)
+		require.Nil(t, req.MetadataContext)
+		require.Nil(t, req.TypedMetadataContext)
+	})
+}
+
+func TestGRPCMetadata(t *testing.T) {
+	var got string
+	svc := New(WithFilters(&requestHeadersFunc{fn: func(req *filter.RequestContext) {
+		got = req.GRPCMetadataValue("x-tenant")
+	}}))
+	srv := newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	})
+	srv.ctx = metadata.NewIncomingContext(srv.ctx, metadata.Pairs("X-Tenant", "gyg"))
+	require.NoError(t, svc.Process(srv))
+	require.Equal(t, "gyg", got)
+}
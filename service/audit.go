@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+)
+
+// auditInterceptor records the mutations made by every filter invocation in the audit trail of the request, see
+// filter.RequestContext.Mutations.
+func auditInterceptor(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	if inv.Writer == nil {
+		return next(ctx, inv)
+	}
+	mark := markMutations(inv.Writer)
+	immediateResponse, err := next(ctx, inv)
+
+	name := filterName(inv.Filter)
+	setHeaders, removeHeaders, body := mark.since(inv.Writer)
+	for _, h := range setHeaders {
+		action := filter.MutationSet
+		if h.GetAppendAction() == corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD {
+			action = filter.MutationAppend
+		}
+		value := h.GetHeader().GetValue()
+		if raw := h.GetHeader().GetRawValue(); raw != nil {
+			value = string(raw)
+		}
+		inv.Request.RecordMutation(filter.Mutation{Stage: inv.Stage, Filter: name, Action: action, Header: h.GetHeader().GetKey(), Value: value})
+	}
+	for _, h := range removeHeaders {
+		inv.Request.RecordMutation(filter.Mutation{Stage: inv.Stage, Filter: name, Action: filter.MutationRemove, Header: h})
+	}
+	if body {
+		inv.Request.RecordMutation(filter.Mutation{Stage: inv.Stage, Filter: name, Action: filter.MutationBody})
+	}
+	return immediateResponse, err
+}
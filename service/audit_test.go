@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type removeHeaderFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *removeHeaderFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	crw.RemoveHeaders("x-next")
+	return nil, nil
+}
+
+func TestAuditTrail(t *testing.T) {
+	var mutations, conflicts []*filter.Mutation
+	inspect := &requestHeadersFunc{fn: func(req *filter.RequestContext) {
+		mutations = req.Mutations()
+		conflicts = req.MutationConflicts()
+	}}
+	srv := newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	})
+	svc := New(WithFilters(&setHeaderFilter{}, &removeHeaderFilter{}, inspect))
+	require.NoError(t, svc.Process(srv))
+
+	require.Len(t, mutations, 2)
+	require.Equal(t, "RequestHeaders:service.setHeaderFilter:set:x-next", mutations[0].String())
+	require.Equal(t, "true", mutations[0].Value)
+	require.Equal(t, "RequestHeaders:service.removeHeaderFilter:remove:x-next", mutations[1].String())
+	require.Equal(t, []*filter.Mutation{mutations[1]}, conflicts)
+	require.Same(t, mutations[0], conflicts[0].Conflict)
+}
+
+func TestAuditTrailRepeatedRemoval(t *testing.T) {
+	var mutations, conflicts []*filter.Mutation
+	inspect := &requestHeadersFunc{fn: func(req *filter.RequestContext) {
+		mutations = req.Mutations()
+		conflicts = req.MutationConflicts()
+	}}
+	srv := newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	})
+	svc := New(WithFilters(&removeHeaderFilter{}, &setHeaderFilter{}, &removeHeaderFilter{}, inspect))
+	require.NoError(t, svc.Process(srv))
+
+	require.Len(t, mutations, 3)
+	require.Equal(t, "RequestHeaders:service.removeHeaderFilter:remove:x-next", mutations[0].String())
+	require.Equal(t, "RequestHeaders:service.setHeaderFilter:set:x-next", mutations[1].String())
+	require.Equal(t, "RequestHeaders:service.removeHeaderFilter:remove:x-next", mutations[2].String())
+	require.Equal(t, []*filter.Mutation{mutations[1], mutations[2]}, conflicts)
+	require.Same(t, mutations[1], conflicts[1].Conflict)
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	extprocfilter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type bodyFilter struct {
+	filter.NoOpFilter
+	name string
+	seen []string
+}
+
+func (f *bodyFilter) RequestBody(_ context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	f.seen = append(f.seen, string(req.RequestBody))
+	crw.BodyMutation(&extproc.BodyMutation{
+		Mutation: &extproc.BodyMutation_Body{Body: []byte(string(req.RequestBody) + f.name)},
+	})
+	return nil, nil
+}
+
+func (f *bodyFilter) ResponseBody(_ context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	f.seen = append(f.seen, string(req.ResponseBody))
+	if string(req.ResponseBody) == "deny" {
+		return filter.NewImmediateResponseBuilder().HTTPStatus(403).ImmediateResponse(), nil
+	}
+	crw.BodyMutation(&extproc.BodyMutation{
+		Mutation: &extproc.BodyMutation_Body{Body: []byte(string(req.ResponseBody) + f.name)},
+	})
+	return nil, nil
+}
+
+func TestBodyFilters(t *testing.T) {
+	t.Run("request body filters run in order and see the mutated body", func(t *testing.T) {
+		a, b := &bodyFilter{name: "-a"}, &bodyFilter{name: "-b"}
+		svc := New(WithFilters(a, &filter.NoOpFilter{}, b))
+		srv := newFakeProcessServer(&extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_RequestBody{
+				RequestBody: &extproc.HttpBody{Body: []byte("body"), EndOfStream: true},
+			},
+		})
+		require.NoError(t, svc.Process(srv))
+
+		require.Equal(t, []string{"body"}, a.seen)
+		require.Equal(t, []string{"body-a"}, b.seen)
+		require.Len(t, srv.responses, 1)
+		res := srv.responses[0].GetRequestBody().GetResponse()
+		require.Equal(t, extproc.CommonResponse_CONTINUE, res.GetStatus())
+		require.Equal(t, "body-a-b", string(res.GetBodyMutation().GetBody()))
+	})
+
+	t.Run("response body filters run in reverse order", func(t *testing.T) {
+		a, b := &bodyFilter{name: "-a"}, &bodyFilter{name: "-b"}
+		svc := New(WithFilters(a, b))
+		srv := newFakeProcessServer(&extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_ResponseBody{
+				ResponseBody: &extproc.HttpBody{Body: []byte("body"), EndOfStream: true},
+			},
+		})
+		require.NoError(t, svc.Process(srv))
+
+		require.Equal(t, []string{"body"}, b.seen)
+		require.Equal(t, []string{"body-b"}, a.seen)
+		require.Len(t, srv.responses, 1)
+		require.Equal(t, "body-b-a", string(srv.responses[0].GetResponseBody().GetResponse().GetBodyMutation().GetBody()))
+	})
+
+	t.Run("immediate response stops the chain", func(t *testing.T) {
+		a, b := &bodyFilter{name: "-a"}, &bodyFilter{name: "-b"}
+		svc := New(WithFilters(a, b))
+		srv := newFakeProcessServer(&extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_ResponseBody{
+				ResponseBody: &extproc.HttpBody{Body: []byte("deny"), EndOfStream: true},
+			},
+		})
+		require.NoError(t, svc.Process(srv))
+
+		require.Empty(t, a.seen)
+		require.Len(t, srv.responses, 1)
+		require.EqualValues(t, 403, srv.responses[0].GetImmediateResponse().GetStatus().GetCode())
+	})
+}
+
+// upperFilter upper-cases the words of the response body, holding back partial words until the next chunk.
+type upperFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *upperFilter) ResponseBodyChunk(_ context.Context, cw *filter.ChunkWriter, _ *filter.RequestContext) error {
+	chunk := cw.Chunk()
+	i := bytes.LastIndexByte(chunk, ' ')
+	if !cw.EndOfStream() && i < len(chunk)-1 {
+		cw.Hold(chunk[i+1:])
+		chunk = chunk[:i+1]
+	}
+	cw.Replace(bytes.ToUpper(chunk))
+	return nil
+}
+
+// dropFilter drops the request body chunks containing "drop".
+type dropFilter struct {
+	filter.NoOpFilter
+	chunks []string
+}
+
+func (f *dropFilter) RequestBodyChunk(_ context.Context, cw *filter.ChunkWriter, _ *filter.RequestContext) error {
+	f.chunks = append(f.chunks, string(cw.Chunk()))
+	if bytes.Contains(cw.Chunk(), []byte("drop")) {
+		cw.Drop()
+	}
+	return nil
+}
+
+func TestBodyChunkFilters(t *testing.T) {
+	responseBody := func(body string, endOfStream bool) *extproc.ProcessingRequest {
+		return &extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_ResponseBody{
+				ResponseBody: &extproc.HttpBody{Body: []byte(body), EndOfStream: endOfStream},
+			},
+		}
+	}
+	requestBody := func(body string, endOfStream bool) *extproc.ProcessingRequest {
+		return &extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_RequestBody{
+				RequestBody: &extproc.HttpBody{Body: []byte(body), EndOfStream: endOfStream},
+			},
+		}
+	}
+
+	t.Run("held data is prepended to the next chunk", func(t *testing.T) {
+		svc := New(WithFilters(&upperFilter{}))
+		srv := newFakeProcessServer(
+			responseBody("hello wo", false),
+			responseBody("rld and ", false),
+			responseBody("bye", true),
+		)
+		require.NoError(t, svc.Process(srv))
+
+		var got []string
+		for _, r := range srv.responses {
+			got = append(got, string(r.GetResponseBody().GetResponse().GetBodyMutation().GetBody()))
+		}
+		require.Equal(t, []string{"HELLO ", "WORLD AND ", "BYE"}, got)
+	})
+
+	t.Run("hold is rejected when the trailers are sent", func(t *testing.T) {
+		mode := &extprocfilter.ProcessingMode{
+			ResponseBodyMode:    extprocfilter.ProcessingMode_STREAMED,
+			ResponseTrailerMode: extprocfilter.ProcessingMode_SEND,
+		}
+		svc := New(WithFilters(&upperFilter{}), WithProcessingMode(mode))
+		err := svc.Process(newFakeProcessServer(responseBody("hello wo", false)))
+		require.ErrorIs(t, err, filter.ErrHoldWithTrailers)
+
+		svc = New(WithFilters(filter.WithErrorPolicy(&upperFilter{}, filter.FailOpen)), WithProcessingMode(mode))
+		srv := newFakeProcessServer(responseBody("hello wo", false), responseBody("rld", true))
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 2)
+		require.Nil(t, srv.responses[0].GetResponseBody().GetResponse().GetBodyMutation().GetMutation())
+		require.Equal(t, "RLD", string(srv.responses[1].GetResponseBody().GetResponse().GetBodyMutation().GetBody()))
+	})
+
+	t.Run("held data followed by trailers fails the filter", func(t *testing.T) {
+		responseTrailers := &extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_ResponseTrailers{ResponseTrailers: &extproc.HttpTrailers{}},
+		}
+		svc := New(WithFilters(&upperFilter{}))
+		err := svc.Process(newFakeProcessServer(responseBody("hello wo", false), responseTrailers))
+		require.ErrorIs(t, err, filter.ErrHoldWithTrailers)
+
+		svc = New(WithFilters(filter.WithErrorPolicy(&upperFilter{}, filter.FailOpen)))
+		srv := newFakeProcessServer(responseBody("hello wo", false), responseTrailers)
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 2)
+		require.NotNil(t, srv.responses[1].GetResponseTrailers())
+	})
+
+	t.Run("next filters see the chunks emitted by the previous filter", func(t *testing.T) {
+		drop := &dropFilter{}
+		last := &dropFilter{}
+		svc := New(WithFilters(drop, last))
+		srv := newFakeProcessServer(
+			requestBody("keep", false),
+			requestBody("drop", false),
+			requestBody("", true),
+		)
+		require.NoError(t, svc.Process(srv))
+
+		require.Equal(t, []string{"keep", "drop", ""}, drop.chunks)
+		require.Equal(t, []string{"keep", ""}, last.chunks)
+		require.Len(t, srv.responses, 3)
+		require.Nil(t, srv.responses[0].GetRequestBody().GetResponse().GetBodyMutation().GetMutation())
+		require.NotNil(t, srv.responses[1].GetRequestBody().GetResponse().GetBodyMutation().GetMutation())
+		require.Empty(t, srv.responses[1].GetRequestBody().GetResponse().GetBodyMutation().GetBody())
+		require.Nil(t, srv.responses[2].GetRequestBody().GetResponse().GetBodyMutation().GetMutation())
+	})
+}
+
+// appendChunkFilter appends its name to the last request body chunk.
+type appendChunkFilter struct {
+	filter.NoOpFilter
+	name string
+}
+
+func (f *appendChunkFilter) RequestBodyChunk(_ context.Context, cw *filter.ChunkWriter, _ *filter.RequestContext) error {
+	if cw.EndOfStream() {
+		cw.Replace(append(bytes.Clone(cw.Chunk()), f.name...))
+	}
+	return nil
+}
+
+// readBodyFilter records the request body without changing it.
+type readBodyFilter struct {
+	filter.NoOpFilter
+	seen []string
+}
+
+func (f *readBodyFilter) RequestBody(_ context.Context, _ *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	f.seen = append(f.seen, string(req.RequestBody))
+	return nil, nil
+}
+
+func TestBodyAndChunkFilters(t *testing.T) {
+	read := &readBodyFilter{}
+	svc := New(WithFilters(&bodyFilter{name: "A"}, &appendChunkFilter{name: "B"}, read))
+	srv := newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestBody{
+			RequestBody: &extproc.HttpBody{EndOfStream: true},
+		},
+	})
+	require.NoError(t, svc.Process(srv))
+
+	require.Equal(t, []string{"AB"}, read.seen)
+	require.Len(t, srv.responses, 1)
+	require.Equal(t, "AB", string(srv.responses[0].GetRequestBody().GetResponse().GetBodyMutation().GetBody()))
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+
+	"github.com/getyourguide/extproc-go/filter"
+)
+
+// chunkPipeline keeps the data held back by the chunk filters between the body messages of one direction of a stream.
+// Filters are identified by their index in ExtProcessor.filters.
+type chunkPipeline struct {
+	held map[int][]byte
+}
+
+func newChunkPipeline() *chunkPipeline {
+	return &chunkPipeline{
+		held: make(map[int][]byte),
+	}
+}
+
+// writer returns the ChunkWriter for the filter at index i, prepending the data it held back on the previous chunk.
+// trailers reports whether the trailers following the body are sent, see filter.ChunkWriter.WithTrailers.
+func (p *chunkPipeline) writer(i int, chunk []byte, endOfStream bool, trailers bool) *filter.ChunkWriter {
+	if held, ok := p.held[i]; ok {
+		chunk = append(held, chunk...)
+		delete(p.held, i)
+	}
+	cw := filter.NewChunkWriter(chunk, endOfStream)
+	if trailers {
+		cw.WithTrailers()
+	}
+	return cw
+}
+
+// commit stores the data held back by the filter at index i and returns the data emitted to the next filter.
+func (p *chunkPipeline) commit(i int, cw *filter.ChunkWriter) []byte {
+	if held := cw.Held(); len(held) > 0 {
+		p.held[i] = bytes.Clone(held)
+	}
+	return cw.Output()
+}
+
+// discard drops the data held back by the filters and returns their indexes in filter order.
+func (p *chunkPipeline) discard() []int {
+	held := slices.Sorted(maps.Keys(p.held))
+	clear(p.held)
+	return held
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type botScoreFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *botScoreFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	crw.DynamicMetadata("bot").SetNumber("score", 0.9).SetBool("blocked", req.RequestHeader("user-agent") == "bot")
+	if req.RequestHeader("user-agent") == "bot" {
+		return filter.NewImmediateResponseBuilder().HTTPStatus(403).ImmediateResponse(), nil
+	}
+	crw.DynamicMetadata("experiments").SetString("bucket", "b")
+	return nil, nil
+}
+
+func TestDynamicMetadata(t *testing.T) {
+	requestHeaders := func(userAgent string) *extproc.ProcessingRequest {
+		return &extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_RequestHeaders{
+				RequestHeaders: &extproc.HttpHeaders{Headers: &corev3.HeaderMap{
+					Headers: []*corev3.HeaderValue{{Key: "user-agent", RawValue: []byte(userAgent)}},
+				}},
+			},
+		}
+	}
+
+	t.Run("attached to the headers response", func(t *testing.T) {
+		svc := New(WithFilters(&botScoreFilter{}))
+		srv := newFakeProcessServer(requestHeaders("curl"))
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 1)
+
+		md := srv.responses[0].GetDynamicMetadata().GetFields()
+		require.Equal(t, 0.9, md["bot"].GetStructValue().GetFields()["score"].GetNumberValue())
+		require.False(t, md["bot"].GetStructValue().GetFields()["blocked"].GetBoolValue())
+		require.Equal(t, "b", md["experiments"].GetStructValue().GetFields()["bucket"].GetStringValue())
+	})
+
+	t.Run("attached to immediate responses", func(t *testing.T) {
+		svc := New(WithFilters(&botScoreFilter{}))
+		srv := newFakeProcessServer(requestHeaders("bot"))
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 1)
+
+		require.NotNil(t, srv.responses[0].GetImmediateResponse())
+		md := srv.responses[0].GetDynamicMetadata().GetFields()
+		require.True(t, md["bot"].GetStructValue().GetFields()["blocked"].GetBoolValue())
+		require.NotContains(t, md, "experiments")
+	})
+
+	t.Run("not set without metadata", func(t *testing.T) {
+		svc := New(WithFilters(&filter.NoOpFilter{}))
+		srv := newFakeProcessServer(requestHeaders("curl"))
+		require.NoError(t, svc.Process(srv))
+		require.Nil(t, srv.responses[0].GetDynamicMetadata())
+	})
+}
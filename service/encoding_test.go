@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type redirectFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *redirectFilter) RequestHeaders(_ context.Context, _ *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	return filter.NewImmediateResponseBuilder().HTTPStatus(http.StatusFound).SetHeader("location", "/login").ImmediateResponse(), nil
+}
+
+func TestHeaderEncoding(t *testing.T) {
+	requestHeaders := func(header *corev3.HeaderValue) *extproc.ProcessingRequest {
+		return &extproc.ProcessingRequest{Request: &extproc.ProcessingRequest_RequestHeaders{
+			RequestHeaders: &extproc.HttpHeaders{Headers: &corev3.HeaderMap{Headers: []*corev3.HeaderValue{header}}},
+		}}
+	}
+	for _, tt := range []struct {
+		name     string
+		options  []Option
+		request  *extproc.ProcessingRequest
+		encoding filter.HeaderEncoding
+	}{{
+		name:     "detect raw_value",
+		request:  requestHeaders(&corev3.HeaderValue{Key: ":path", RawValue: []byte("/")}),
+		encoding: filter.HeaderEncodingRawValue,
+	}, {
+		name:     "detect value",
+		request:  requestHeaders(&corev3.HeaderValue{Key: ":path", Value: "/"}),
+		encoding: filter.HeaderEncodingValue,
+	}, {
+		name:     "configured",
+		options:  []Option{WithHeaderEncoding(filter.HeaderEncodingValue)},
+		request:  requestHeaders(&corev3.HeaderValue{Key: ":path", RawValue: []byte("/")}),
+		encoding: filter.HeaderEncodingValue,
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			assertEncoding := func(t *testing.T, h *corev3.HeaderValue, value string) {
+				t.Helper()
+				if tt.encoding == filter.HeaderEncodingValue {
+					require.Equal(t, value, h.GetValue())
+					require.Empty(t, h.GetRawValue())
+				} else {
+					require.Equal(t, []byte(value), h.GetRawValue())
+					require.Empty(t, h.GetValue())
+				}
+			}
+
+			srv := newFakeProcessServer(tt.request)
+			require.NoError(t, New(append(tt.options, WithFilters(&setHeaderFilter{}))...).Process(srv))
+			assertEncoding(t, srv.responses[0].GetRequestHeaders().GetResponse().GetHeaderMutation().GetSetHeaders()[0].GetHeader(), "true")
+
+			srv = newFakeProcessServer(tt.request)
+			require.NoError(t, New(append(tt.options, WithFilters(&redirectFilter{}))...).Process(srv))
+			assertEncoding(t, srv.responses[0].GetImmediateResponse().GetHeaders().GetSetHeaders()[0].GetHeader(), "/login")
+		})
+	}
+}
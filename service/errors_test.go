@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type failingFilter struct {
+	filter.NoOpFilter
+	err error
+}
+
+func (f *failingFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	return nil, f.err
+}
+
+// failOpenFilter defines its own error policy.
+type failOpenFilter struct {
+	failingFilter
+}
+
+func (f *failOpenFilter) ErrorPolicy() filter.ErrorPolicy {
+	return filter.FailOpen
+}
+
+type setHeaderFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *setHeaderFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	crw.SetHeader("x-next", "true")
+	return nil, nil
+}
+
+func TestErrorPolicy(t *testing.T) {
+	errFailed := errors.New("failed")
+	requestHeaders := &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}
+
+	for _, tt := range []struct {
+		name       string
+		options    []Option
+		wantErr    error
+		wantStatus int
+		wantNext   bool
+	}{{
+		name:    "abort the stream by default",
+		options: []Option{WithFilters(&failingFilter{err: errFailed}, &setHeaderFilter{})},
+		wantErr: errFailed,
+	}, {
+		name:     "fail open continues the chain",
+		options:  []Option{WithFilters(filter.WithErrorPolicy(&failingFilter{err: errFailed}, filter.FailOpen), &setHeaderFilter{})},
+		wantNext: true,
+	}, {
+		name:     "policy defined by the filter",
+		options:  []Option{WithFilters(&failOpenFilter{failingFilter{err: errFailed}}, &setHeaderFilter{})},
+		wantNext: true,
+	}, {
+		name:       "respond on error",
+		options:    []Option{WithFilters(filter.WithErrorPolicy(&failingFilter{err: errFailed}, filter.RespondOnError(http.StatusServiceUnavailable, []byte("unavailable"))), &setHeaderFilter{})},
+		wantStatus: http.StatusServiceUnavailable,
+	}, {
+		name:       "respond on error defaults to internal server error",
+		options:    []Option{WithFilters(filter.WithErrorPolicy(&failingFilter{err: errFailed}, filter.ErrorPolicy{Action: filter.ErrorActionRespond}))},
+		wantStatus: http.StatusInternalServerError,
+	}, {
+		name: "service default policy",
+		options: []Option{
+			WithFilters(&failingFilter{err: errFailed}, &setHeaderFilter{}),
+			WithErrorPolicy(filter.FailOpen),
+		},
+		wantNext: true,
+	}, {
+		name: "filter policy wins over the service default",
+		options: []Option{
+			WithFilters(filter.WithErrorPolicy(&failingFilter{err: errFailed}, filter.FailClosed), &setHeaderFilter{}),
+			WithErrorPolicy(filter.FailOpen),
+		},
+		wantErr: errFailed,
+	}, {
+		name:       "abort errors always reply with the status",
+		options:    []Option{WithFilters(filter.WithErrorPolicy(&failingFilter{err: filter.AbortWithStatus(http.StatusForbidden)}, filter.FailOpen), &setHeaderFilter{})},
+		wantStatus: http.StatusForbidden,
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := New(tt.options...)
+			srv := newFakeProcessServer(requestHeaders)
+			err := svc.Process(srv)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.Empty(t, srv.responses)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, srv.responses, 1)
+			if tt.wantStatus != 0 {
+				require.EqualValues(t, tt.wantStatus, srv.responses[0].GetImmediateResponse().GetStatus().GetCode())
+			}
+			if tt.wantNext {
+				setHeaders := srv.responses[0].GetRequestHeaders().GetResponse().GetHeaderMutation().GetSetHeaders()
+				require.Len(t, setHeaders, 1)
+				require.Equal(t, "x-next", setHeaders[0].GetHeader().GetKey())
+			}
+		})
+	}
+}
+
+func TestIgnoredErrorLog(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	svc := New(WithFilters(filter.WithErrorPolicy(&failingFilter{err: errors.New("failed")}, filter.FailOpen)))
+	require.NoError(t, svc.Process(newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	})))
+	require.Contains(t, logs.String(), `msg="ignoring filter error"`)
+	require.Contains(t, logs.String(), "filter=service.failingFilter ")
+}
+
+type panickingFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *panickingFilter) RequestHeaders(_ context.Context, _ *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	var m map[string]string
+	m["boom"] = "boom"
+	return nil, nil
+}
+
+func (f *panickingFilter) OnStreamComplete(_ *filter.RequestContext) error {
+	panic("boom")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	requestHeaders := &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}
+
+	t.Run("panic aborts the stream by default", func(t *testing.T) {
+		svc := New(WithFilters(&panickingFilter{}))
+		err := svc.Process(newFakeProcessServer(requestHeaders))
+		var panicErr *PanicError
+		require.ErrorAs(t, err, &panicErr)
+		require.Contains(t, string(panicErr.Stack), "panickingFilter")
+	})
+
+	t.Run("panic follows the error policy of the filter", func(t *testing.T) {
+		svc := New(WithFilters(
+			filter.WithErrorPolicy(&panickingFilter{}, filter.RespondOnError(http.StatusInternalServerError, nil)),
+		))
+		srv := newFakeProcessServer(requestHeaders)
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 1)
+		require.EqualValues(t, http.StatusInternalServerError, srv.responses[0].GetImmediateResponse().GetStatus().GetCode())
+	})
+
+	t.Run("panic is logged with the default logger", func(t *testing.T) {
+		var logs bytes.Buffer
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+		svc := New(WithFilters(&panickingFilter{}))
+		require.Error(t, svc.Process(newFakeProcessServer(requestHeaders)))
+		require.Contains(t, logs.String(), `msg="recovered panic in filter"`)
+		require.Contains(t, logs.String(), "filter=service.panickingFilter")
+	})
+
+	panickingCondition := func(*filter.RequestContext) bool {
+		panic("boom")
+	}
+
+	t.Run("panicking condition follows the error policy of the filter", func(t *testing.T) {
+		svc := New(WithFilters(filter.WithErrorPolicy(
+			filter.When(&setHeaderFilter{}, panickingCondition),
+			filter.RespondOnError(http.StatusServiceUnavailable, nil),
+		)))
+		srv := newFakeProcessServer(requestHeaders)
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 1)
+		require.EqualValues(t, http.StatusServiceUnavailable, srv.responses[0].GetImmediateResponse().GetStatus().GetCode())
+	})
+
+	t.Run("ignored panicking condition skips the filter", func(t *testing.T) {
+		svc := New(WithFilters(filter.WithErrorPolicy(filter.When(&setHeaderFilter{}, panickingCondition), filter.FailOpen)))
+		srv := newFakeProcessServer(requestHeaders)
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 1)
+		require.Empty(t, srv.responses[0].GetRequestHeaders().GetResponse().GetHeaderMutation().GetSetHeaders())
+	})
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/getyourguide/extproc-go/filter"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// dynamicMetadata returns the dynamic metadata of the response, set by the filters on crw, with the values of the
+// keys exported by WithDynamicMetadataKeys. Envoy merges the namespaces, so values are exported with every response.
+func (svc *ExtProcessor) dynamicMetadata(req *filter.RequestContext, crw *filter.CommonResponseWriter) *structpb.Struct {
+	for namespace, keys := range svc.dynamicMetadataKeys {
+		for _, key := range keys {
+			v, ok := key.Value(req)
+			if !ok {
+				continue
+			}
+			crw.DynamicMetadata(namespace).SetValue(key.Name(), metadataValue(v))
+		}
+	}
+	return crw.DynamicMetadataStruct()
+}
+
+// metadataValue converts a metadata value to a protobuf value. Values that are not JSON-like are formatted as strings.
+func metadataValue(v any) *structpb.Value {
+	if value, err := structpb.NewValue(v); err == nil {
+		return value
+	}
+	if s, ok := v.(fmt.Stringer); ok {
+		return structpb.NewStringValue(s.String())
+	}
+	return structpb.NewStringValue(fmt.Sprintf("%v", v))
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportedKeys(t *testing.T) {
+	userID := filter.NewKey[string]("user_id")
+	plan := filter.NewKey[int]("plan")
+	setKeys := &requestHeadersFunc{fn: func(req *filter.RequestContext) {
+		userID.Set(req, "42")
+		plan.Set(req, 2)
+	}}
+	requestHeaders := &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}
+
+	t.Run("dynamic metadata", func(t *testing.T) {
+		srv := newFakeProcessServer(requestHeaders)
+		svc := New(WithFilters(setKeys), WithDynamicMetadataKeys("com.example.auth", userID, plan))
+		require.NoError(t, svc.Process(srv))
+
+		require.Len(t, srv.responses, 1)
+		ns := srv.responses[0].GetDynamicMetadata().GetFields()["com.example.auth"].GetStructValue()
+		require.Equal(t, "42", ns.GetFields()["user_id"].GetStringValue())
+		require.EqualValues(t, 2, ns.GetFields()["plan"].GetNumberValue())
+	})
+
+	t.Run("access log", func(t *testing.T) {
+		var lines []string
+		log := funcr.New(func(prefix, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{})
+		jsonFormat, err := ParseAccessLogJSONFormat(map[string]string{"user": "%METADATA(user_id)%"})
+		require.NoError(t, err)
+
+		svc := New(WithFilters(setKeys), WithLogger(log), WithAccessLog(jsonFormat), WithAccessLogKeys(plan))
+		require.NoError(t, svc.Process(newFakeProcessServer(requestHeaders)))
+		require.Equal(t, []string{`"level"=0 "msg"="access log" "user"="42" "plan"=2`}, lines)
+	})
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Invocation describes the invocation of a filter at a stage of the stream, see Interceptor.
+type Invocation struct {
+	// Filter is the invoked filter, unwrapped from filter.When or filter.WithErrorPolicy.
+	Filter filter.Filter
+	// Stage is the resource name of the stage, e.g. RequestHeadersResourceName or StreamCompleteResourceName.
+	Stage   string
+	Request *filter.RequestContext
+	// Writer is the writer of the response sent to Envoy. It is nil for the body chunk, match and stream complete stages.
+	Writer *filter.CommonResponseWriter
+}
+
+// Invoker runs a filter invocation, or the next interceptor of the chain.
+type Invoker func(ctx context.Context, inv *Invocation) (*extproc.ProcessingResponse_ImmediateResponse, error)
+
+// Interceptor wraps every filter invocation at every stage: headers, bodies, trailers and stream complete.
+// It can run code before and after calling next and inspect its result, or return its own result without calling
+// next. Stages without an immediate response, like StreamCompleteResourceName, ignore the returned immediate response.
+type Interceptor func(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error)
+
+// TracingInterceptor starts a span named after the filter and the stage, e.g. "*filters.SameSiteLaxMode/RequestHeaders",
+// around every filter invocation. It is always the first interceptor of the service, using the tracer of WithTracer.
+func TracingInterceptor(tracer trace.Tracer) Interceptor {
+	return func(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("%T/%s", inv.Filter, inv.Stage))
+		defer span.End()
+		immediateResponse, err := next(ctx, inv)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return immediateResponse, err
+	}
+}
+
+// PanicError is the error returned in place of a panic of a filter. It is handled according to the error policy of the filter.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// recoveryInterceptor recovers a panic of the next interceptors or the filter and returns it as a *PanicError,
+// after recording it on the span of ctx, logging its stack and counting it in the metrics.
+func (svc *ExtProcessor) recoveryInterceptor(ctx context.Context, inv *Invocation, next Invoker) (immediateResponse *extproc.ProcessingResponse_ImmediateResponse, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+		filterName := filterName(inv.Filter)
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(panicErr, trace.WithAttributes(attribute.String("exception.stacktrace", string(panicErr.Stack))))
+		svc.log.Error(panicErr, "recovered panic in filter", "filter", filterName, "stage", inv.Stage, "stack", string(panicErr.Stack))
+		svc.metrics.panics.Add(ctx, 1, metric.WithAttributes(attribute.String("filter", filterName), attribute.String("stage", inv.Stage)))
+		immediateResponse, err = nil, panicErr
+	}()
+	return next(ctx, inv)
+}
+
+// invoke runs fn, the invocation of a filter, through the chain of interceptors.
+func (svc *ExtProcessor) invoke(ctx context.Context, inv *Invocation, fn func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error)) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	invoker := func(ctx context.Context, _ *Invocation) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+		return fn(ctx)
+	}
+	for i := len(svc.chain) - 1; i >= 0; i-- {
+		interceptor, next := svc.chain[i], invoker
+		invoker = func(ctx context.Context, inv *Invocation) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return interceptor(ctx, inv, next)
+		}
+	}
+	return invoker(ctx, inv)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptors(t *testing.T) {
+	requests := []*extproc.ProcessingRequest{{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}, {
+		Request: &extproc.ProcessingRequest_RequestBody{RequestBody: &extproc.HttpBody{Body: []byte("body"), EndOfStream: true}},
+	}}
+
+	t.Run("interceptors wrap every invocation in order", func(t *testing.T) {
+		var seen []string
+		record := func(name string) Interceptor {
+			return func(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+				seen = append(seen, fmt.Sprintf("%s:%T/%s", name, inv.Filter, inv.Stage))
+				return next(ctx, inv)
+			}
+		}
+		f := &streamCompleteFilter{bodyFilter: bodyFilter{name: "-a"}}
+		svc := New(WithFilters(f), WithInterceptors(record("first")), WithInterceptors(record("second")))
+		require.NoError(t, svc.Process(newFakeProcessServer(requests...)))
+
+		require.Equal(t, []string{
+			"first:*service.streamCompleteFilter/RequestHeaders",
+			"second:*service.streamCompleteFilter/RequestHeaders",
+			"first:*service.streamCompleteFilter/RequestBody",
+			"second:*service.streamCompleteFilter/RequestBody",
+			"first:*service.streamCompleteFilter/StreamComplete",
+			"second:*service.streamCompleteFilter/StreamComplete",
+		}, seen)
+		require.True(t, f.completed)
+	})
+
+	t.Run("interceptor can reply without calling the filter", func(t *testing.T) {
+		deny := func(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			if inv.Stage == RequestHeadersResourceName {
+				return filter.NewImmediateResponseBuilder().HTTPStatus(http.StatusForbidden).ImmediateResponse(), nil
+			}
+			return next(ctx, inv)
+		}
+		f := &bodyFilter{name: "-a"}
+		srv := newFakeProcessServer(requests[0])
+		require.NoError(t, New(WithFilters(f), WithInterceptors(deny)).Process(srv))
+
+		require.Len(t, srv.responses, 1)
+		require.EqualValues(t, http.StatusForbidden, srv.responses[0].GetImmediateResponse().GetStatus().GetCode())
+	})
+
+	t.Run("interceptor sees the result of the filter", func(t *testing.T) {
+		var results []error
+		observe := func(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			immediateResponse, err := next(ctx, inv)
+			results = append(results, err)
+			return immediateResponse, err
+		}
+		errFailed := errors.New("failed")
+		svc := New(WithFilters(filter.WithErrorPolicy(&failingFilter{err: errFailed}, filter.FailOpen)), WithInterceptors(observe))
+		require.NoError(t, svc.Process(newFakeProcessServer(requests[0])))
+
+		require.Equal(t, []error{errFailed}, results)
+	})
+}
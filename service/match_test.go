@@ -0,0 +1,58 @@
+package service
+
+import (
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type streamCompleteFilter struct {
+	bodyFilter
+	completed bool
+}
+
+func (f *streamCompleteFilter) OnStreamComplete(_ *filter.RequestContext) error {
+	f.completed = true
+	return nil
+}
+
+func TestMatchedFilters(t *testing.T) {
+	requests := func(path string) []*extproc.ProcessingRequest {
+		return []*extproc.ProcessingRequest{{
+			Request: &extproc.ProcessingRequest_RequestHeaders{
+				RequestHeaders: &extproc.HttpHeaders{Headers: &corev3.HeaderMap{
+					Headers: []*corev3.HeaderValue{{Key: ":path", RawValue: []byte(path)}},
+				}},
+			},
+		}, {
+			Request: &extproc.ProcessingRequest_RequestBody{
+				RequestBody: &extproc.HttpBody{Body: []byte("body"), EndOfStream: true},
+			},
+		}}
+	}
+
+	t.Run("optional interfaces of the wrapped filter run when matching", func(t *testing.T) {
+		f := &streamCompleteFilter{bodyFilter: bodyFilter{name: "-a"}}
+		svc := New(WithFilters(filter.When(f, filter.PathPrefix("/api/"))))
+		srv := newFakeProcessServer(requests("/api/users")...)
+		require.NoError(t, svc.Process(srv))
+
+		require.Equal(t, []string{"body"}, f.seen)
+		require.True(t, f.completed)
+	})
+
+	t.Run("the wrapped filter is skipped for every stage when not matching", func(t *testing.T) {
+		f := &streamCompleteFilter{bodyFilter: bodyFilter{name: "-a"}}
+		svc := New(WithFilters(filter.When(f, filter.PathPrefix("/api/"))))
+		srv := newFakeProcessServer(requests("/static/app.js")...)
+		require.NoError(t, svc.Process(srv))
+
+		require.Empty(t, f.seen)
+		require.False(t, f.completed)
+		require.Len(t, srv.responses, 2)
+		require.Nil(t, srv.responses[1].GetRequestBody().GetResponse().GetBodyMutation().GetMutation())
+	})
+}
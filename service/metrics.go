@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// metrics holds the instruments of the metrics recorded by the service, see WithMeter.
+type metrics struct {
+	streams            metric.Int64Counter
+	activeStreams      metric.Int64UpDownCounter
+	streamDuration     metric.Float64Histogram
+	stageDuration      metric.Float64Histogram
+	filterDuration     metric.Float64Histogram
+	filterErrors       metric.Int64Counter
+	panics             metric.Int64Counter
+	immediateResponses metric.Int64Counter
+	mutations          metric.Int64Counter
+	mutationViolations metric.Int64Counter
+}
+
+// newMetrics creates the instruments with meter. Instruments failing to be created are replaced by no-ops by the
+// OpenTelemetry API, so errors are ignored.
+func newMetrics(meter metric.Meter) *metrics {
+	m := &metrics{}
+	m.streams, _ = meter.Int64Counter("extproc.streams",
+		metric.WithDescription("Number of ext_proc streams started."),
+	)
+	m.activeStreams, _ = meter.Int64UpDownCounter("extproc.streams.active",
+		metric.WithDescription("Number of ext_proc streams in progress."),
+	)
+	m.streamDuration, _ = meter.Float64Histogram("extproc.stream.duration",
+		metric.WithDescription("Duration of ext_proc streams."),
+		metric.WithUnit("s"),
+	)
+	m.stageDuration, _ = meter.Float64Histogram("extproc.stage.duration",
+		metric.WithDescription("Duration of the processing of a message by all the filters."),
+		metric.WithUnit("s"),
+	)
+	m.filterDuration, _ = meter.Float64Histogram("extproc.filter.duration",
+		metric.WithDescription("Duration of the invocation of a filter."),
+		metric.WithUnit("s"),
+	)
+	m.filterErrors, _ = meter.Int64Counter("extproc.filter.errors",
+		metric.WithDescription("Number of errors returned by filters, including recovered panics."),
+	)
+	m.panics, _ = meter.Int64Counter("extproc.filter.panics",
+		metric.WithDescription("Number of panics recovered in filters."),
+	)
+	m.immediateResponses, _ = meter.Int64Counter("extproc.immediate_responses",
+		metric.WithDescription("Number of immediate responses sent to Envoy."),
+	)
+	m.mutations, _ = meter.Int64Counter("extproc.mutations",
+		metric.WithDescription("Number of header and body mutations made by filters."),
+	)
+	m.mutationViolations, _ = meter.Int64Counter("extproc.mutation_violations",
+		metric.WithDescription("Number of header mutations made by filters violating the mutation rules."),
+	)
+	return m
+}
+
+// filterName returns the label of f in metrics, its type name, e.g. "filters.SameSiteLaxMode".
+func filterName(f filter.Filter) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", f), "*")
+}
+
+// metricsInterceptor records the duration, errors and mutations of every filter invocation.
+func (svc *ExtProcessor) metricsInterceptor(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	mark := markMutations(inv.Writer)
+	start := time.Now()
+	immediateResponse, err := next(ctx, inv)
+	filterAttr, stageAttr := attribute.String("filter", filterName(inv.Filter)), attribute.String("stage", inv.Stage)
+	svc.metrics.filterDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(filterAttr, stageAttr))
+	if err != nil {
+		svc.metrics.filterErrors.Add(ctx, 1, metric.WithAttributes(filterAttr, stageAttr))
+	}
+
+	record := func(kind string, n int) {
+		if n > 0 {
+			svc.metrics.mutations.Add(ctx, int64(n), metric.WithAttributes(filterAttr, stageAttr, attribute.String("type", kind)))
+		}
+	}
+	setHeaders, removeHeaders, body := mark.since(inv.Writer)
+	record("set_header", len(setHeaders))
+	record("remove_header", len(removeHeaders))
+	if body {
+		record("body", 1)
+	}
+	return immediateResponse, err
+}
+
+// recordImmediateResponse counts an immediate response sent to Envoy at the given stage.
+func (m *metrics) recordImmediateResponse(ctx context.Context, stage string, immediateResponse *extproc.ProcessingResponse_ImmediateResponse) {
+	status := strconv.Itoa(int(immediateResponse.ImmediateResponse.GetStatus().GetCode()))
+	m.immediateResponses.Add(ctx, 1, metric.WithAttributes(attribute.String("stage", stage), attribute.String("status", status)))
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	svc := New(WithFilters(&setHeaderFilter{}, &bodyFilter{name: "-a"}), WithMeter(provider.Meter("test")))
+
+	srv := newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}, &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_ResponseBody{ResponseBody: &extproc.HttpBody{Body: []byte("deny"), EndOfStream: true}},
+	})
+	require.NoError(t, svc.Process(srv))
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	got := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+
+	sum := func(name string, attrs ...attribute.KeyValue) int64 {
+		t.Helper()
+		require.Contains(t, got, name)
+		var total int64
+		for _, dp := range got[name].(metricdata.Sum[int64]).DataPoints {
+			if dp.Attributes.Equals(ptr(attribute.NewSet(attrs...))) || len(attrs) == 0 {
+				total += dp.Value
+			}
+		}
+		return total
+	}
+	histogramCount := func(name string, attrs ...attribute.KeyValue) uint64 {
+		t.Helper()
+		require.Contains(t, got, name)
+		var total uint64
+		for _, dp := range got[name].(metricdata.Histogram[float64]).DataPoints {
+			if dp.Attributes.Equals(ptr(attribute.NewSet(attrs...))) {
+				total += dp.Count
+			}
+		}
+		return total
+	}
+
+	require.EqualValues(t, 1, sum("extproc.streams"))
+	require.EqualValues(t, 0, sum("extproc.streams.active"))
+	require.EqualValues(t, 1, histogramCount("extproc.stream.duration"))
+	require.EqualValues(t, 1, histogramCount("extproc.stage.duration", attribute.String("stage", RequestHeadersResourceName)))
+	require.EqualValues(t, 1, histogramCount("extproc.filter.duration",
+		attribute.String("filter", "service.setHeaderFilter"), attribute.String("stage", RequestHeadersResourceName)))
+	require.EqualValues(t, 1, histogramCount("extproc.filter.duration",
+		attribute.String("filter", "service.bodyFilter"), attribute.String("stage", ResponseBodyResourceName)))
+	require.EqualValues(t, 1, sum("extproc.mutations",
+		attribute.String("filter", "service.setHeaderFilter"), attribute.String("stage", RequestHeadersResourceName), attribute.String("type", "set_header")))
+	require.EqualValues(t, 1, sum("extproc.immediate_responses",
+		attribute.String("stage", ResponseBodyResourceName), attribute.String("status", "403")))
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocfilter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+// staticAssetsFilter skips the response headers of static assets.
+type staticAssetsFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *staticAssetsFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	if strings.HasPrefix(req.URL().Path, "/static/") {
+		crw.SendResponseHeaders(false)
+	}
+	return nil, nil
+}
+
+// bufferedBodyFilter needs the whole response body of HTML pages.
+type bufferedBodyFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *bufferedBodyFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	if strings.HasSuffix(req.URL().Path, ".html") {
+		crw.SendResponseHeaders(true).ResponseBodyMode(extprocfilter.ProcessingMode_BUFFERED)
+	}
+	return nil, nil
+}
+
+func TestModeOverride(t *testing.T) {
+	requestHeaders := func(path string) *extproc.ProcessingRequest {
+		return &extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_RequestHeaders{
+				RequestHeaders: &extproc.HttpHeaders{Headers: &corev3.HeaderMap{
+					Headers: []*corev3.HeaderValue{{Key: ":path", RawValue: []byte(path)}},
+				}},
+			},
+		}
+	}
+	base := &extprocfilter.ProcessingMode{
+		RequestBodyMode:     extprocfilter.ProcessingMode_STREAMED,
+		ResponseBodyMode:    extprocfilter.ProcessingMode_STREAMED,
+		ResponseTrailerMode: extprocfilter.ProcessingMode_SEND,
+	}
+
+	for _, tt := range []struct {
+		name string
+		path string
+		want *extprocfilter.ProcessingMode
+	}{{
+		name: "no declaration keeps the configured mode",
+		path: "/index",
+	}, {
+		name: "skip is merged with the configured mode",
+		path: "/static/app.js",
+		want: &extprocfilter.ProcessingMode{
+			ResponseHeaderMode:  extprocfilter.ProcessingMode_SKIP,
+			RequestBodyMode:     extprocfilter.ProcessingMode_STREAMED,
+			ResponseBodyMode:    extprocfilter.ProcessingMode_STREAMED,
+			ResponseTrailerMode: extprocfilter.ProcessingMode_SEND,
+		},
+	}, {
+		name: "need wins over skip",
+		path: "/static/index.html",
+		want: &extprocfilter.ProcessingMode{
+			ResponseHeaderMode:  extprocfilter.ProcessingMode_SEND,
+			RequestBodyMode:     extprocfilter.ProcessingMode_STREAMED,
+			ResponseBodyMode:    extprocfilter.ProcessingMode_BUFFERED,
+			ResponseTrailerMode: extprocfilter.ProcessingMode_SEND,
+		},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := New(
+				WithFilters(&staticAssetsFilter{}, &bufferedBodyFilter{}),
+				WithProcessingMode(base),
+			)
+			srv := newFakeProcessServer(requestHeaders(tt.path))
+			require.NoError(t, svc.Process(srv))
+			require.Len(t, srv.responses, 1)
+			got := srv.responses[0].GetModeOverride()
+			if tt.want == nil {
+				require.Nil(t, got)
+				return
+			}
+			require.Equal(t, tt.want.String(), got.String())
+		})
+	}
+}
+
+func TestModeOverrideWithoutProcessingMode(t *testing.T) {
+	svc := New(WithFilters(&bufferedBodyFilter{}))
+	srv := newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{
+			RequestHeaders: &extproc.HttpHeaders{Headers: &corev3.HeaderMap{
+				Headers: []*corev3.HeaderValue{{Key: ":path", RawValue: []byte("/index.html")}},
+			}},
+		},
+	})
+	require.NoError(t, svc.Process(srv))
+	require.Len(t, srv.responses, 1)
+	require.Nil(t, srv.responses[0].GetModeOverride())
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// mutationRulesInterceptor validates the header mutations made by every filter invocation, including the headers of
+// its immediate response, against the mutation rules set by WithMutationRules. Violations are counted and logged, or
+// returned as the filter error with WithStrictMutationRules.
+func (svc *ExtProcessor) mutationRulesInterceptor(ctx context.Context, inv *Invocation, next Invoker) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	if inv.Writer == nil {
+		return next(ctx, inv)
+	}
+	mark := markMutations(inv.Writer)
+	immediateResponse, err := next(ctx, inv)
+	if err != nil {
+		return immediateResponse, err
+	}
+
+	setHeaders, removeHeaders, _ := mark.since(inv.Writer)
+	violations := svc.mutationRules.CheckHeaderMutation(&extproc.HeaderMutation{SetHeaders: setHeaders, RemoveHeaders: removeHeaders})
+	if immediateResponse != nil {
+		violations = append(violations, svc.mutationRules.CheckHeaderMutation(immediateResponse.ImmediateResponse.GetHeaders())...)
+	}
+	if len(violations) == 0 {
+		return immediateResponse, nil
+	}
+
+	name := filterName(inv.Filter)
+	errs := make([]error, len(violations))
+	for i, v := range violations {
+		errs[i] = v
+		svc.metrics.mutationViolations.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("filter", name), attribute.String("stage", inv.Stage), attribute.Bool("fails", v.Fails),
+		))
+		if !svc.strictMutationRules {
+			svc.log.Info("header mutation violates the mutation rules", "filter", name, "stage", inv.Stage, "violation", v.Error(), "fails", v.Fails)
+		}
+	}
+	if svc.strictMutationRules {
+		return nil, errors.Join(errs...)
+	}
+	return immediateResponse, nil
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/require"
+)
+
+type envoyHeaderFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *envoyHeaderFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	crw.SetHeader("x-envoy-retry-on", "5xx")
+	return nil, nil
+}
+
+func TestMutationRules(t *testing.T) {
+	rules, err := filter.NewMutationRules(nil)
+	require.NoError(t, err)
+	requestHeaders := &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		svc := New(WithFilters(&envoyHeaderFilter{}, &setHeaderFilter{}), WithStrictMutationRules(rules))
+		err := svc.Process(newFakeProcessServer(requestHeaders))
+		var violation *filter.MutationViolation
+		require.ErrorAs(t, err, &violation)
+		require.Equal(t, "x-envoy-retry-on", violation.Header)
+	})
+
+	t.Run("log", func(t *testing.T) {
+		var lines []string
+		log := funcr.New(func(prefix, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{})
+		svc := New(WithFilters(&envoyHeaderFilter{}, &setHeaderFilter{}), WithMutationRules(rules), WithLogger(log))
+		srv := newFakeProcessServer(requestHeaders)
+		require.NoError(t, svc.Process(srv))
+		require.Len(t, srv.responses, 1)
+		require.Equal(t, []string{
+			`"level"=0 "msg"="header mutation violates the mutation rules" "filter"="service.envoyHeaderFilter" "stage"="RequestHeaders" "violation"="set header \"x-envoy-retry-on\": x-envoy headers require allow_envoy" "fails"=false`,
+		}, lines)
+	})
+}
@@ -1,8 +1,11 @@
 package service
 
 import (
+	extprocfilter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
 	"github.com/getyourguide/extproc-go/filter"
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -16,6 +19,8 @@ func (o optionFunc) apply(f *ExtProcessor) {
 	o(f)
 }
 
+// WithLogger sets the logger of the service, used for filter errors, recovered panics and the access log.
+// It defaults to the slog default logger.
 func WithLogger(log logr.Logger) Option {
 	return optionFunc(func(svc *ExtProcessor) {
 		svc.log = log
@@ -26,13 +31,13 @@ func WithFilters(filters ...filter.Filter) Option {
 	return optionFunc(func(svc *ExtProcessor) {
 		svc.filters = filters
 
-		var streams []filter.Stream
+		svc.streamCallbacks = false
 		for _, f := range filters {
-			if s, ok := f.(filter.Stream); ok {
-				streams = append(streams, s)
+			switch unwrapFilter(f).(type) {
+			case filter.Stream, filter.StreamResultCallback:
+				svc.streamCallbacks = true
 			}
 		}
-		svc.streamCallbacks = streams
 	})
 }
 
@@ -41,3 +46,115 @@ func WithTracer(tracer trace.Tracer) Option {
 		svc.tracer = tracer
 	})
 }
+
+// WithMeter sets the meter recording the metrics of the service.
+func WithMeter(meter metric.Meter) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.meter = meter
+	})
+}
+
+// WithProcessingMode sets the processing_mode configured in the Envoy ext_proc filter.
+// It is the base of the mode_override built from the filters declarations, since Envoy replaces its whole processing
+// mode with the override. When it is not set, no mode_override is sent and the declarations are ignored.
+func WithProcessingMode(mode *extprocfilter.ProcessingMode) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.processingMode = mode
+	})
+}
+
+// WithErrorPolicy sets the policy handling the errors returned by the filters that do not define their own policy
+// with filter.WithErrorPolicy. It defaults to filter.FailClosed, which aborts the stream.
+func WithErrorPolicy(policy filter.ErrorPolicy) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.errorPolicy = policy
+	})
+}
+
+// WithInterceptors adds interceptors wrapping every filter invocation, see Interceptor.
+// Interceptors run in the given order, inside the built-in ones: tracing, metrics, the mutation audit, the mutation
+// rules when set with WithMutationRules, then panic recovery. The built-in metrics and audit interceptors therefore see
+// what the user interceptors return, including their errors and the results they replace.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.interceptors = append(svc.interceptors, interceptors...)
+	})
+}
+
+// WithPropagator sets the propagator extracting the trace of the HTTP request from the gRPC metadata of the stream, or
+// else from the request headers. It defaults to the W3C trace context and baggage, and the B3 headers.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.propagator = propagator
+	})
+}
+
+// WithAccessLog writes an access log line per stream through the logger of the service, named "accesslog", with the
+// given format. See ParseAccessLogFormat and ParseAccessLogJSONFormat.
+func WithAccessLog(format *AccessLogFormat) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.accessLog = format
+	})
+}
+
+// WithAccessLogSampleRate sets the fraction of streams, between 0 and 1, with an access log. It defaults to 1.
+func WithAccessLogSampleRate(rate float64) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.accessLogSampleRate = rate
+	})
+}
+
+// WithClientIPPolicy sets the trust policy of RequestContext.ClientIP for every stream.
+// It defaults to filter.DefaultClientIPPolicy.
+func WithClientIPPolicy(policy filter.ClientIPPolicy) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.clientIPPolicy = &policy
+	})
+}
+
+// WithDynamicMetadataKeys exports the values of the given request metadata keys as Envoy dynamic metadata in the
+// namespace, with every response sent to Envoy once they are set. Envoy only accepts the namespaces listed in
+// metadata_options.receiving_namespaces of the ext_proc filter.
+func WithDynamicMetadataKeys(namespace string, keys ...filter.MetadataKey) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		if svc.dynamicMetadataKeys == nil {
+			svc.dynamicMetadataKeys = make(map[string][]filter.MetadataKey)
+		}
+		svc.dynamicMetadataKeys[namespace] = append(svc.dynamicMetadataKeys[namespace], keys...)
+	})
+}
+
+// WithAccessLogKeys adds the values of the given request metadata keys as fields of the JSON access log, named after
+// the keys. Text formats reference them with %METADATA(name)%.
+func WithAccessLogKeys(keys ...filter.MetadataKey) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.accessLogKeys = append(svc.accessLogKeys, keys...)
+	})
+}
+
+// WithMutationRules validates the header mutations of the filters against the mutation_rules of the Envoy ext_proc
+// filter. Violations are logged and counted by the extproc.mutation_violations metric.
+func WithMutationRules(rules *filter.MutationRules) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.mutationRules = rules
+		svc.strictMutationRules = false
+	})
+}
+
+// WithStrictMutationRules is like WithMutationRules but returns the violations as filter errors, handled by the error
+// policy of the filter. It is meant for tests.
+func WithStrictMutationRules(rules *filter.MutationRules) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.mutationRules = rules
+		svc.strictMutationRules = true
+	})
+}
+
+// WithHeaderEncoding sets the encoding of the header values written to Envoy, which must match the
+// envoy_reloadable_features_send_header_raw_value runtime guard of Envoy. It defaults to filter.HeaderEncodingAuto,
+// detecting the encoding from the first message of every stream, or raw_value if the message has no header values.
+func WithHeaderEncoding(encoding filter.HeaderEncoding) Option {
+	return optionFunc(func(svc *ExtProcessor) {
+		svc.headerEncoding = encoding
+	})
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"io"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"google.golang.org/grpc"
+)
+
+// fakeProcessServer replays the given requests to ExtProcessor.Process and records the responses.
+type fakeProcessServer struct {
+	grpc.ServerStream
+	ctx       context.Context
+	requests  []*extproc.ProcessingRequest
+	responses []*extproc.ProcessingResponse
+}
+
+var _ extproc.ExternalProcessor_ProcessServer = &fakeProcessServer{}
+
+func newFakeProcessServer(requests ...*extproc.ProcessingRequest) *fakeProcessServer {
+	return &fakeProcessServer{
+		ctx:      context.Background(),
+		requests: requests,
+	}
+}
+
+func (s *fakeProcessServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeProcessServer) Recv() (*extproc.ProcessingRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	r := s.requests[0]
+	s.requests = s.requests[1:]
+	return r, nil
+}
+
+func (s *fakeProcessServer) Send(r *extproc.ProcessingResponse) error {
+	s.responses = append(s.responses, r)
+	return nil
+}
+
+// requestHeadersFunc is a filter calling fn on request headers.
+type requestHeadersFunc struct {
+	filter.NoOpFilter
+	fn func(req *filter.RequestContext)
+}
+
+func (f *requestHeadersFunc) RequestHeaders(_ context.Context, _ *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	f.fn(req)
+	return nil, nil
+}
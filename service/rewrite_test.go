@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type routeCacheFilter struct {
+	filter.NoOpFilter
+}
+
+func (f *routeCacheFilter) RequestHeaders(_ context.Context, crw *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	crw.SetPath("/rewritten")
+	return nil, nil
+}
+
+func (f *routeCacheFilter) ResponseHeaders(_ context.Context, crw *filter.CommonResponseWriter, _ *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	crw.SetHeader("host", "example.com").ClearRouteCache(true)
+	return nil, nil
+}
+
+func TestClearRouteCache(t *testing.T) {
+	srv := newFakeProcessServer(&extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}, &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_ResponseHeaders{ResponseHeaders: &extproc.HttpHeaders{}},
+	})
+	require.NoError(t, New(WithFilters(&routeCacheFilter{})).Process(srv))
+	require.Len(t, srv.responses, 2)
+	require.True(t, srv.responses[0].GetRequestHeaders().GetResponse().GetClearRouteCache())
+	require.False(t, srv.responses[1].GetResponseHeaders().GetResponse().GetClearRouteCache())
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"errors"
@@ -8,16 +9,26 @@ import (
 	"io"
 	"log/slog"
 	"maps"
+	"net/http"
+	"time"
 
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocfilter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
 	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	"github.com/getyourguide/extproc-go/filter"
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
 	grpcodes "google.golang.org/grpc/codes"
 
 	"go.opentelemetry.io/otel/trace"
 	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
@@ -26,32 +37,77 @@ const (
 )
 
 var (
-	RequestHeadersResourceName   = "RequestHeaders"
-	RequestBodyResourceName      = "RequestBody"
-	RequestTrailersResourceName  = "RequestTrailers"
-	ResponseHeadersResourceName  = "ResponseHeaders"
-	ResponseBodyResourceName     = "ResponseBody"
-	ResponseTrailersResourceName = "ResponseTrailers"
-	StreamCompleteResourceName   = "StreamComplete"
+	RequestHeadersResourceName    = "RequestHeaders"
+	RequestBodyResourceName       = "RequestBody"
+	RequestBodyChunkResourceName  = "RequestBodyChunk"
+	RequestTrailersResourceName   = "RequestTrailers"
+	ResponseHeadersResourceName   = "ResponseHeaders"
+	ResponseBodyResourceName      = "ResponseBody"
+	ResponseBodyChunkResourceName = "ResponseBodyChunk"
+	ResponseTrailersResourceName  = "ResponseTrailers"
+	StreamCompleteResourceName    = "StreamComplete"
+	StreamStartResourceName       = "StreamStart"
+	StreamMessageResourceName     = "StreamMessage"
+	StreamErrorResourceName       = "StreamError"
+	StreamCancelResourceName      = "StreamCancel"
+	ImmediateResponseResourceName = "ImmediateResponse"
+	MatchResourceName             = "Match"
 )
 
 type ExtProcessor struct {
-	filters         []filter.Filter
-	streamCallbacks []filter.Stream
-	log             logr.Logger
-	tracer          trace.Tracer
+	filters             []filter.Filter
+	streamCallbacks     bool
+	errorPolicies       []filter.ErrorPolicy
+	errorPolicy         filter.ErrorPolicy
+	processingMode      *extprocfilter.ProcessingMode
+	log                 logr.Logger
+	tracer              trace.Tracer
+	propagator          propagation.TextMapPropagator
+	meter               metric.Meter
+	metrics             *metrics
+	interceptors        []Interceptor
+	accessLog           *AccessLogFormat
+	accessLogSampleRate float64
+	clientIPPolicy      *filter.ClientIPPolicy
+	dynamicMetadataKeys map[string][]filter.MetadataKey
+	accessLogKeys       []filter.MetadataKey
+	headerEncoding      filter.HeaderEncoding
+	mutationRules       *filter.MutationRules
+	// strictMutationRules returns mutation rules violations as filter errors instead of logging them.
+	strictMutationRules bool
+	chain               []Interceptor
 }
 
 var _ extproc.ExternalProcessorServer = &ExtProcessor{}
 
 func New(options ...Option) *ExtProcessor {
-	f := &ExtProcessor{}
+	f := &ExtProcessor{accessLogSampleRate: 1}
 	for _, opt := range options {
 		opt.apply(f)
 	}
+	if f.log.GetSink() == nil {
+		f.log = logr.FromSlogHandler(slog.Default().Handler())
+	}
 	if f.tracer == nil {
 		f.tracer = noop.NewTracerProvider().Tracer(TraceMessageOperationName)
 	}
+	if f.propagator == nil {
+		f.propagator = defaultPropagator
+	}
+	if f.meter == nil {
+		f.meter = metricnoop.NewMeterProvider().Meter(TraceMessageOperationName)
+	}
+	f.metrics = newMetrics(f.meter)
+	f.chain = []Interceptor{TracingInterceptor(f.tracer), f.metricsInterceptor, auditInterceptor}
+	if f.mutationRules != nil {
+		f.chain = append(f.chain, f.mutationRulesInterceptor)
+	}
+	f.chain = append(f.chain, f.recoveryInterceptor)
+	f.chain = append(f.chain, f.interceptors...)
+	f.errorPolicies = make([]filter.ErrorPolicy, len(f.filters))
+	for i, flt := range f.filters {
+		f.errorPolicies[i] = errorPolicy(flt, f.errorPolicy)
+	}
 
 	return f
 }
@@ -61,75 +117,294 @@ func New(options ...Option) *ExtProcessor {
 // https://www.envoyproxy.io/docs/envoy/latest/api-v3/extensions/filters/http/ext_proc/v3/ext_proc.proto#envoy-v3-api-msg-extensions-filters-http-ext-proc-v3-externalfilter
 func (svc *ExtProcessor) Process(procsrv extproc.ExternalProcessor_ProcessServer) error {
 	req := filter.NewRequestContext()
-	ctx := procsrv.Context()
-	if len(svc.streamCallbacks) > 0 {
-		defer func() {
-			ctx, span := svc.tracer.Start(ctx, StreamCompleteResourceName)
-			defer span.End()
-			for _, s := range svc.streamCallbacks {
-				resourceName := fmt.Sprintf("%T/%s", s, StreamCompleteResourceName)
-				_, span := svc.tracer.Start(ctx, resourceName)
-				if err := s.OnStreamComplete(req); err != nil {
-					slog.Error(fmt.Sprintf("%T.%s returned an error", s, StreamCompleteResourceName), "err", err.Error())
-				}
-				span.End()
-			}
-		}()
+	req.GRPCMetadata, _ = metadata.FromIncomingContext(procsrv.Context())
+	if svc.clientIPPolicy != nil {
+		req.SetClientIPPolicy(*svc.clientIPPolicy)
+	}
+	requestChunks, responseChunks := newChunkPipeline(), newChunkPipeline()
+	ctx, traced := svc.extractTraceContext(procsrv.Context(), metadataCarrier(req.GRPCMetadata))
+	start := time.Now()
+	svc.metrics.streams.Add(ctx, 1)
+	svc.metrics.activeStreams.Add(ctx, 1)
+	defer func() {
+		svc.metrics.activeStreams.Add(ctx, -1)
+		svc.metrics.streamDuration.Record(ctx, time.Since(start).Seconds())
+	}()
+	s := &stream{
+		start:          start,
+		req:            req,
+		accessLog:      svc.sampleAccessLog(),
+		headerEncoding: svc.headerEncoding,
+		processingMode: svc.processingMode,
+	}
+	ctx = contextWithStream(ctx, s)
+	if s.accessLog {
+		defer svc.writeAccessLog(s)
+	}
+	defer func() {
+		s.result.Duration = time.Since(start)
+		if !svc.streamCallbacks {
+			return
+		}
+		ctx, span := svc.tracer.Start(ctx, StreamCompleteResourceName, trace.WithAttributes(spanAttributes(req)...))
+		defer span.End()
+		runStreamCallbacks(ctx, svc, req, StreamCompleteResourceName, func(f filter.Stream) error {
+			return f.OnStreamComplete(req)
+		})
+		runStreamCallbacks(ctx, svc, req, StreamCompleteResourceName, func(f filter.StreamResultCallback) error {
+			return f.OnStreamResult(req, &s.result)
+		})
+	}()
+	runStreamCallbacks(ctx, svc, req, StreamStartResourceName, func(f filter.StreamStartCallback) error {
+		f.OnStreamStart(req)
+		return nil
+	})
+	// ended records how the stream ended and notifies the filters of an error or a cancellation.
+	ended := func(err error) error {
+		switch {
+		case isCanceled(err):
+			s.result.Canceled = true
+			runStreamCallbacks(ctx, svc, req, StreamCancelResourceName, func(f filter.StreamCancelCallback) error {
+				f.OnStreamCancel(req)
+				return nil
+			})
+		case err != nil && !errors.Is(err, io.EOF):
+			s.result.Err = err
+			runStreamCallbacks(ctx, svc, req, StreamErrorResourceName, func(f filter.StreamErrorCallback) error {
+				f.OnStreamError(req, err)
+				return nil
+			})
+		}
+		return IgnoreCanceled(err)
 	}
 
 	for {
 		procreq, err := procsrv.Recv()
 		if err != nil {
-			return IgnoreCanceled(err)
+			return ended(err)
 		}
 
+		if s.headerEncoding == filter.HeaderEncodingAuto {
+			s.headerEncoding = cmp.Or(filter.DetectHeaderEncoding(procreq), filter.HeaderEncodingRawValue)
+		}
+		mergeMetadataContextIntoReq(req, procreq.GetMetadataContext())
+		if msg, ok := procreq.Request.(*extproc.ProcessingRequest_RequestHeaders); ok && !traced {
+			ctx, traced = svc.extractTraceContext(ctx, headerMapCarrier{msg.RequestHeaders.GetHeaders()})
+		}
 		ctx := logr.NewContext(ctx, svc.log)
+		var stage string
+		var handle func(ctx context.Context) error
 		switch msg := procreq.Request.(type) {
 		case *extproc.ProcessingRequest_RequestHeaders:
-			ctx, span := svc.tracer.Start(ctx, RequestHeadersResourceName)
-			if err := svc.requestHeadersMessage(ctx, req, msg, procreq.GetAttributes(), procsrv); err != nil {
-				span.End()
-				return IgnoreCanceled(err)
+			stage = RequestHeadersResourceName
+			handle = func(ctx context.Context) error {
+				return svc.requestHeadersMessage(ctx, req, msg, procreq.GetAttributes(), procsrv)
 			}
-			span.End()
 		case *extproc.ProcessingRequest_RequestBody:
-			ctx, span := svc.tracer.Start(ctx, RequestBodyResourceName)
-			if err := svc.requestBodyMessage(ctx, req, msg, procsrv); err != nil {
-				span.End()
-				return IgnoreCanceled(err)
+			stage = RequestBodyResourceName
+			handle = func(ctx context.Context) error {
+				return svc.requestBodyMessage(ctx, req, msg, requestChunks, procsrv)
 			}
-			span.End()
 		case *extproc.ProcessingRequest_RequestTrailers:
-			ctx, span := svc.tracer.Start(ctx, RequestTrailersResourceName)
-			if err := svc.requestTrailersMessage(ctx, req, msg, procsrv); err != nil {
-				span.End()
-				return IgnoreCanceled(err)
+			stage = RequestTrailersResourceName
+			handle = func(ctx context.Context) error {
+				return svc.requestTrailersMessage(ctx, req, msg, requestChunks, procsrv)
 			}
-			span.End()
 		case *extproc.ProcessingRequest_ResponseHeaders:
-			ctx, span := svc.tracer.Start(ctx, ResponseHeadersResourceName)
-			if err := svc.responseHeadersMessage(ctx, req, msg, procreq.GetAttributes(), procsrv); err != nil {
-				span.End()
-				return IgnoreCanceled(err)
+			stage = ResponseHeadersResourceName
+			handle = func(ctx context.Context) error {
+				return svc.responseHeadersMessage(ctx, req, msg, procreq.GetAttributes(), procsrv)
 			}
-			span.End()
 		case *extproc.ProcessingRequest_ResponseBody:
-			ctx, span := svc.tracer.Start(ctx, ResponseBodyResourceName)
-			if err := svc.responseBodyMessage(ctx, req, msg, procsrv); err != nil {
-				span.End()
-				return IgnoreCanceled(err)
+			stage = ResponseBodyResourceName
+			handle = func(ctx context.Context) error {
+				return svc.responseBodyMessage(ctx, req, msg, responseChunks, procsrv)
 			}
-			span.End()
 		case *extproc.ProcessingRequest_ResponseTrailers:
-			ctx, span := svc.tracer.Start(ctx, ResponseTrailersResourceName)
-			if err := svc.responseTrailersMessage(ctx, req, msg, procsrv); err != nil {
-				span.End()
-				return IgnoreCanceled(err)
+			stage = ResponseTrailersResourceName
+			handle = func(ctx context.Context) error {
+				return svc.responseTrailersMessage(ctx, req, msg, responseChunks, procsrv)
 			}
-			span.End()
 		default:
-			return fmt.Errorf("unknown request type: %T", procreq.Request)
+			return ended(fmt.Errorf("unknown request type: %T", procreq.Request))
+		}
+
+		start := time.Now()
+		ctx, span := svc.tracer.Start(ctx, stage)
+		err = handle(ctx)
+		span.SetAttributes(spanAttributes(req)...)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		svc.metrics.stageDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("stage", stage)))
+		s.result.Stages = append(s.result.Stages, stage)
+		if err != nil {
+			return ended(err)
+		}
+		runStreamCallbacks(ctx, svc, req, StreamMessageResourceName, func(f filter.StreamMessageCallback) error {
+			f.OnStreamMessage(req, stage)
+			return nil
+		})
+	}
+}
+
+// sendImmediateResponse sends the immediate response returned by an invocation, with the dynamic metadata set by the filters.
+func (svc *ExtProcessor) sendImmediateResponse(ctx context.Context, procsrv extproc.ExternalProcessor_ProcessServer, inv *Invocation, immediateResponse *extproc.ProcessingResponse_ImmediateResponse, crw *filter.CommonResponseWriter) error {
+	svc.metrics.recordImmediateResponse(ctx, inv.Stage, immediateResponse)
+	if s := streamFromContext(ctx); s != nil {
+		s.headerEncoding.EncodeHeaderMutation(immediateResponse.ImmediateResponse.GetHeaders())
+		s.result.ImmediateResponse = immediateResponse.ImmediateResponse
+		s.result.ShortCircuitFilter = inv.Filter
+		s.result.ShortCircuitStage = inv.Stage
+	}
+	trace.SpanFromContext(ctx).SetAttributes(immediateResponseStatusKey.Int(int(immediateResponse.ImmediateResponse.GetStatus().GetCode())))
+	err := procsrv.Send(&extproc.ProcessingResponse{
+		Response:        immediateResponse,
+		DynamicMetadata: svc.dynamicMetadata(inv.Request, crw),
+	})
+	if err != nil {
+		return err
+	}
+	runStreamCallbacks(ctx, svc, inv.Request, ImmediateResponseResourceName, func(f filter.ImmediateResponseCallback) error {
+		f.OnImmediateResponse(inv.Request, immediateResponse.ImmediateResponse)
+		return nil
+	})
+	return nil
+}
+
+// runStreamCallbacks runs fn on the filters implementing the stream callback T, through the interceptors.
+// Errors are logged since the stream cannot be changed anymore. The conditions of the filters are not evaluated on stream
+// start, and a filter whose conditions fail is skipped.
+func runStreamCallbacks[T any](ctx context.Context, svc *ExtProcessor, req *filter.RequestContext, stage string, fn func(T) error) {
+	for i, f := range svc.filters {
+		if stage == StreamStartResourceName {
+			f = unwrapFilter(f)
+		} else if resolved, ok, _, _ := svc.resolveFilter(ctx, i, req); ok {
+			f = resolved
+		} else {
+			continue
+		}
+		callback, ok := f.(T)
+		if !ok {
+			continue
+		}
+		inv := &Invocation{Filter: f, Stage: stage, Request: req}
+		_, err := svc.invoke(ctx, inv, func(context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return nil, fn(callback)
+		})
+		if err != nil {
+			svc.log.Error(err, "stream callback failed", "filter", filterName(f), "stage", stage)
+		}
+	}
+}
+
+// isCanceled reports whether err is caused by the cancellation of the stream.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || status.Code(err) == grpcodes.Canceled
+}
+
+// onFilterError applies the error policy of the filter at index i to the error returned by the filter f.
+// It returns the immediate response to send instead of running the next filters, or the error aborting the stream.
+// Both are nil when the error is ignored. A filter.AbortError is always converted to an immediate response.
+func (svc *ExtProcessor) onFilterError(i int, stage string, f filter.Filter, err error) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	var abortErr *filter.AbortError
+	if errors.As(err, &abortErr) {
+		return abortErr.ImmediateResponse(), nil
+	}
+	policy := svc.errorPolicies[i]
+	switch policy.Action {
+	case filter.ErrorActionIgnore:
+		svc.log.Error(err, "ignoring filter error", "filter", filterName(f), "stage", stage)
+		return nil, nil
+	case filter.ErrorActionRespond:
+		return filter.NewImmediateResponseBuilder().
+			HTTPStatus(cmp.Or(policy.Status, http.StatusInternalServerError)).
+			Body(policy.Body).
+			ImmediateResponse(), nil
+	}
+	return nil, fmt.Errorf("%s: failed running filter %T: %w", stage, f, err)
+}
+
+// errorPolicy returns the error policy of f, the first one defined while unwrapping it, or def if there is none.
+func errorPolicy(f filter.Filter, def filter.ErrorPolicy) filter.ErrorPolicy {
+	for {
+		if p, ok := f.(filter.ErrorPolicyProvider); ok {
+			return p.ErrorPolicy()
+		}
+		w, ok := f.(filter.Wrapper)
+		if !ok {
+			return def
+		}
+		f = w.Unwrap()
+	}
+}
+
+// resolveFilter returns the innermost filter wrapped by the filter at index i, see filter.Wrapper, and whether every
+// layer matches the request, see filter.Matcher. Optional interfaces are looked up on the innermost filter.
+//
+// The matchers are evaluated once per stream through the interceptors, as the MatchResourceName stage, so a panicking
+// condition is recovered like a panicking filter. The filter then does not match, and the error policy of the filter
+// is applied: it returns the immediate response to send or the error aborting the stream.
+func (svc *ExtProcessor) resolveFilter(ctx context.Context, i int, req *filter.RequestContext) (filter.Filter, bool, *extproc.ProcessingResponse_ImmediateResponse, error) {
+	f := unwrapFilter(svc.filters[i])
+	if !hasMatcher(svc.filters[i]) {
+		return f, true, nil, nil
+	}
+	s := streamFromContext(ctx)
+	if matched, ok := s.matched(i); ok {
+		return f, matched, nil, nil
+	}
+	matched := false
+	inv := &Invocation{Filter: f, Stage: MatchResourceName, Request: req}
+	_, err := svc.invoke(ctx, inv, func(context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+		matched = matchFilter(svc.filters[i], req)
+		return nil, nil
+	})
+	s.setMatched(i, matched && err == nil)
+	if err != nil {
+		immediateResponse, err := svc.onFilterError(i, inv.Stage, f, err)
+		return f, false, immediateResponse, err
+	}
+	return f, matched, nil, nil
+}
+
+// hasMatcher reports whether f or a filter it wraps is a filter.Matcher.
+func hasMatcher(f filter.Filter) bool {
+	for {
+		if _, ok := f.(filter.Matcher); ok {
+			return true
+		}
+		w, ok := f.(filter.Wrapper)
+		if !ok {
+			return false
 		}
+		f = w.Unwrap()
+	}
+}
+
+// matchFilter reports whether every layer of f matches the request, see filter.Matcher.
+func matchFilter(f filter.Filter, req *filter.RequestContext) bool {
+	for {
+		if m, ok := f.(filter.Matcher); ok && !m.Match(req) {
+			return false
+		}
+		w, ok := f.(filter.Wrapper)
+		if !ok {
+			return true
+		}
+		f = w.Unwrap()
+	}
+}
+
+// unwrapFilter returns the innermost filter wrapped by f, see filter.Wrapper.
+func unwrapFilter(f filter.Filter) filter.Filter {
+	for {
+		w, ok := f.(filter.Wrapper)
+		if !ok {
+			return f
+		}
+		f = w.Unwrap()
 	}
 }
 
@@ -137,16 +412,34 @@ func (svc *ExtProcessor) Process(procsrv extproc.ExternalProcessor_ProcessServer
 // Fields are merged per namespace rather than the namespace being overwritten,
 // since request and response stage attributes may share a namespace.
 func mergeAttributesIntoReq(req *filter.RequestContext, attrs map[string]*structpb.Struct) {
-	if len(attrs) == 0 {
+	req.Attributes = mergeNamespaces(req.Attributes, attrs)
+}
+
+// mergeMetadataContextIntoReq merges the Envoy filter metadata forwarded with a message into req.MetadataContext and
+// req.TypedMetadataContext. Like attributes, untyped fields are merged per namespace, typed namespaces are replaced.
+func mergeMetadataContextIntoReq(req *filter.RequestContext, md *corev3.Metadata) {
+	req.MetadataContext = mergeNamespaces(req.MetadataContext, md.GetFilterMetadata())
+	if len(md.GetTypedFilterMetadata()) == 0 {
 		return
 	}
-	if req.Attributes == nil {
-		req.Attributes = make(map[string]*structpb.Struct, len(attrs))
+	if req.TypedMetadataContext == nil {
+		req.TypedMetadataContext = make(map[string]*anypb.Any, len(md.GetTypedFilterMetadata()))
+	}
+	maps.Copy(req.TypedMetadataContext, md.GetTypedFilterMetadata())
+}
+
+// mergeNamespaces merges the fields of each namespace of src into dst and returns dst, allocating it if needed.
+func mergeNamespaces(dst map[string]*structpb.Struct, src map[string]*structpb.Struct) map[string]*structpb.Struct {
+	if len(src) == 0 {
+		return dst
 	}
-	for namespace, s := range attrs {
-		existing, ok := req.Attributes[namespace]
+	if dst == nil {
+		dst = make(map[string]*structpb.Struct, len(src))
+	}
+	for namespace, s := range src {
+		existing, ok := dst[namespace]
 		if !ok || existing == nil {
-			req.Attributes[namespace] = s
+			dst[namespace] = s
 			continue
 		}
 		if existing.Fields == nil {
@@ -154,51 +447,64 @@ func mergeAttributesIntoReq(req *filter.RequestContext, attrs map[string]*struct
 		}
 		maps.Copy(existing.Fields, s.GetFields())
 	}
+	return dst
 }
 
 // Step 1. Request headers: Contains the headers from the original HTTP request.
 func (svc *ExtProcessor) requestHeadersMessage(ctx context.Context, req *filter.RequestContext, msg *extproc.ProcessingRequest_RequestHeaders, attrs map[string]*structpb.Struct, procsrv extproc.ExternalProcessor_ProcessServer) error {
 	for _, header := range msg.RequestHeaders.GetHeaders().GetHeaders() {
-		headerValue := cmp.Or(string(header.GetRawValue()), header.GetValue())
+		headerValue := filter.HeaderValueString(header)
 		req.RequestHeaders.Add(header.Key, headerValue)
 	}
 	mergeAttributesIntoReq(req, attrs)
-	crw := filter.NewCommonResponseWriter(req.RequestHeaders)
+	crw := newCommonResponseWriter(ctx, req.RequestHeaders)
 
-	for _, f := range svc.filters {
+	for i := range svc.filters {
 		select {
 		case <-ctx.Done():
 			return nil
 		default:
 		}
-		resourceName := fmt.Sprintf("%T/RequestHeaders", f)
-		ctx, span := svc.tracer.Start(ctx, resourceName)
-		// span.AddAttributes(trace.StringAttribute("filter", fmt.Sprintf("%T", f)))
-
-		immediateResponse, err := f.RequestHeaders(ctx, crw, req)
+		f, ok, immediateResponse, err := svc.resolveFilter(ctx, i, req)
 		if err != nil {
-			span.SetStatus(codes.Error, err.Error())
-			span.End()
-			return fmt.Errorf("RequestHeaders: failed running filter %T: %w", f, err)
+			return err
 		}
 		if immediateResponse != nil {
-			span.End()
-			return procsrv.Send(&extproc.ProcessingResponse{
-				Response: immediateResponse,
-			})
+			inv := &Invocation{Filter: f, Stage: MatchResourceName, Request: req, Writer: crw}
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if !ok {
+			continue
+		}
+		inv := &Invocation{Filter: f, Stage: RequestHeadersResourceName, Request: req, Writer: crw}
+		immediateResponse, err = svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return f.RequestHeaders(ctx, crw, req)
+		})
+		if err != nil {
+			immediateResponse, err = svc.onFilterError(i, inv.Stage, f, err)
+			if err != nil {
+				return err
+			}
+		}
+		if immediateResponse != nil {
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
 		}
 		if err := crw.CommonResponse().Validate(); err != nil {
-			span.End()
 			return fmt.Errorf("RequestHeaders: failed validating response in filter %T: %w", f, err)
 		}
-		span.End()
+	}
+	modeOverride := crw.ModeOverride(svc.processingMode)
+	if s := streamFromContext(ctx); s != nil && modeOverride != nil {
+		s.processingMode = modeOverride
 	}
 	r := &extproc.ProcessingResponse{
 		Response: &extproc.ProcessingResponse_RequestHeaders{
 			RequestHeaders: &extproc.HeadersResponse{
-				Response: crw.CommonResponse(),
+				Response: crw.Coalesce().CommonResponse(),
 			},
 		},
+		DynamicMetadata: svc.dynamicMetadata(req, crw),
+		ModeOverride:    modeOverride,
 	}
 	if err := r.ValidateAll(); err != nil {
 		return fmt.Errorf("RequestHeaders: failed validating response in filter: %w", err)
@@ -210,10 +516,93 @@ func (svc *ExtProcessor) requestHeadersMessage(ctx context.Context, req *filter.
 	return nil
 }
 
-// Step 2. (Not implemented) Request body: Delivered if they are present and sent in a single message if the BUFFERED or BUFFERED_PARTIAL mode is chosen, in multiple messages if the STREAMED mode is chosen, and not at all otherwise.
-func (svc *ExtProcessor) requestBodyMessage(_ context.Context, _ *filter.RequestContext, _ *extproc.ProcessingRequest_RequestBody, procsrv extproc.ExternalProcessor_ProcessServer) error {
+// Step 2. Request body: Delivered if they are present and sent in a single message if the BUFFERED or BUFFERED_PARTIAL mode is chosen, in multiple messages if the STREAMED mode is chosen, and not at all otherwise.
+// Each message runs through the RequestBodyChunkFilter and RequestBodyFilter implementations in filter order.
+func (svc *ExtProcessor) requestBodyMessage(ctx context.Context, req *filter.RequestContext, msg *extproc.ProcessingRequest_RequestBody, chunks *chunkPipeline, procsrv extproc.ExternalProcessor_ProcessServer) error {
+	req.RequestBody = msg.RequestBody.GetBody()
+	endOfStream := msg.RequestBody.GetEndOfStream()
+	requestTrailers, _ := streamFromContext(ctx).trailerModes()
+	crw := newCommonResponseWriter(ctx, req.RequestHeaders)
+	modified := false
+
+	for i := range svc.filters {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		f, ok, immediateResponse, err := svc.resolveFilter(ctx, i, req)
+		if err != nil {
+			return err
+		}
+		if immediateResponse != nil {
+			inv := &Invocation{Filter: f, Stage: MatchResourceName, Request: req, Writer: crw}
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if !ok {
+			continue
+		}
+		if cf, ok := f.(filter.RequestBodyChunkFilter); ok && (len(req.RequestBody) > 0 || endOfStream) {
+			cw := chunks.writer(i, req.RequestBody, endOfStream, requestTrailers)
+			inv := &Invocation{Filter: f, Stage: RequestBodyChunkResourceName, Request: req}
+			_, err := svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+				if err := cf.RequestBodyChunk(ctx, cw, req); err != nil {
+					return nil, err
+				}
+				return nil, cw.Err()
+			})
+			if err != nil {
+				immediateResponse, err := svc.onFilterError(i, inv.Stage, f, err)
+				if err != nil {
+					return err
+				}
+				if immediateResponse != nil {
+					return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+				}
+				// The error is ignored: the chunk received by the filter is passed unchanged to the next filter.
+				modified = modified || !bytes.Equal(cw.Chunk(), req.RequestBody)
+				req.RequestBody = cw.Chunk()
+				continue
+			}
+			modified = modified || cw.Modified() || !bytes.Equal(cw.Chunk(), req.RequestBody)
+			req.RequestBody = chunks.commit(i, cw)
+		}
+
+		bf, ok := f.(filter.RequestBodyFilter)
+		if !ok {
+			continue
+		}
+		inv := &Invocation{Filter: f, Stage: RequestBodyResourceName, Request: req, Writer: crw}
+		immediateResponse, err = svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return bf.RequestBody(ctx, crw, req)
+		})
+		if err != nil {
+			immediateResponse, err = svc.onFilterError(i, inv.Stage, f, err)
+			if err != nil {
+				return err
+			}
+		}
+		if immediateResponse != nil {
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if err := crw.CommonResponse().Validate(); err != nil {
+			return fmt.Errorf("RequestBody: failed validating response in filter %T: %w", f, err)
+		}
+		if body, ok := consumeBodyMutation(crw, req.RequestBody); ok {
+			req.RequestBody = body
+			modified = true
+		}
+	}
 	r := &extproc.ProcessingResponse{
-		Response: &extproc.ProcessingResponse_RequestBody{},
+		Response: &extproc.ProcessingResponse_RequestBody{
+			RequestBody: &extproc.BodyResponse{
+				Response: bodyResponse(crw, req.RequestBody, modified),
+			},
+		},
+		DynamicMetadata: svc.dynamicMetadata(req, crw),
+	}
+	if err := r.ValidateAll(); err != nil {
+		return fmt.Errorf("RequestBody: failed validating response: %w", err)
 	}
 	if err := procsrv.Send(r); err != nil {
 		return fmt.Errorf("RequestBody: failed sending response: %w", err)
@@ -221,10 +610,73 @@ func (svc *ExtProcessor) requestBodyMessage(_ context.Context, _ *filter.Request
 	return nil
 }
 
-// Step 3. (Not implemented) Request trailers: Delivered if they are present and if the trailer mode is set to SEND.
-func (svc *ExtProcessor) requestTrailersMessage(_ context.Context, _ *filter.RequestContext, _ *extproc.ProcessingRequest_RequestTrailers, procsrv extproc.ExternalProcessor_ProcessServer) error {
+// Step 3. Request trailers: Delivered if they are present and if the trailer mode is set to SEND.
+// The trailers run through the RequestTrailersFilter implementations in filter order.
+func (svc *ExtProcessor) requestTrailersMessage(ctx context.Context, req *filter.RequestContext, msg *extproc.ProcessingRequest_RequestTrailers, chunks *chunkPipeline, procsrv extproc.ExternalProcessor_ProcessServer) error {
+	if req.RequestTrailers == nil {
+		req.RequestTrailers = make(http.Header)
+	}
+	for _, header := range msg.RequestTrailers.GetTrailers().GetHeaders() {
+		headerValue := filter.HeaderValueString(header)
+		req.RequestTrailers.Add(header.Key, headerValue)
+	}
+	crw := newCommonResponseWriter(ctx, req.RequestTrailers)
+	inv, immediateResponse, err := svc.discardHeldChunks(RequestTrailersResourceName, req, crw, chunks)
+	if err != nil {
+		return err
+	}
+	if immediateResponse != nil {
+		return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+	}
+
+	for i := range svc.filters {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		f, ok, immediateResponse, err := svc.resolveFilter(ctx, i, req)
+		if err != nil {
+			return err
+		}
+		if immediateResponse != nil {
+			inv := &Invocation{Filter: f, Stage: MatchResourceName, Request: req, Writer: crw}
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if !ok {
+			continue
+		}
+		tf, ok := f.(filter.RequestTrailersFilter)
+		if !ok {
+			continue
+		}
+		inv := &Invocation{Filter: f, Stage: RequestTrailersResourceName, Request: req, Writer: crw}
+		immediateResponse, err = svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return tf.RequestTrailers(ctx, crw, req)
+		})
+		if err != nil {
+			immediateResponse, err = svc.onFilterError(i, inv.Stage, f, err)
+			if err != nil {
+				return err
+			}
+		}
+		if immediateResponse != nil {
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if err := crw.CommonResponse().Validate(); err != nil {
+			return fmt.Errorf("RequestTrailers: failed validating response in filter %T: %w", f, err)
+		}
+	}
 	r := &extproc.ProcessingResponse{
-		Response: &extproc.ProcessingResponse_RequestTrailers{},
+		Response: &extproc.ProcessingResponse_RequestTrailers{
+			RequestTrailers: &extproc.TrailersResponse{
+				HeaderMutation: crw.Coalesce().CommonResponse().GetHeaderMutation(),
+			},
+		},
+		DynamicMetadata: svc.dynamicMetadata(req, crw),
+	}
+	if err := r.ValidateAll(); err != nil {
+		return fmt.Errorf("RequestTrailers: failed validating response: %w", err)
 	}
 	if err := procsrv.Send(r); err != nil {
 		return fmt.Errorf("RequestTrailers: failed sending response: %w", err)
@@ -235,48 +687,54 @@ func (svc *ExtProcessor) requestTrailersMessage(_ context.Context, _ *filter.Req
 // Step 4. Response headers: Contains the headers from the HTTP response. Keep in mind that if the upstream system sends them before processing the request body that this message may arrive before the complete body.
 func (svc *ExtProcessor) responseHeadersMessage(ctx context.Context, req *filter.RequestContext, msg *extproc.ProcessingRequest_ResponseHeaders, attrs map[string]*structpb.Struct, procsrv extproc.ExternalProcessor_ProcessServer) error {
 	for _, header := range msg.ResponseHeaders.GetHeaders().GetHeaders() {
-		headerValue := cmp.Or(string(header.GetRawValue()), header.GetValue())
+		headerValue := filter.HeaderValueString(header)
 		req.ResponseHeaders.Add(header.Key, headerValue)
 	}
 	mergeAttributesIntoReq(req, attrs)
-	crw := filter.NewCommonResponseWriter(req.ResponseHeaders)
+	crw := newCommonResponseWriter(ctx, req.ResponseHeaders)
 
 	for i := len(svc.filters) - 1; i >= 0; i-- {
-		f := svc.filters[i]
 		select {
 		case <-ctx.Done():
 			return nil
 		default:
 		}
-		resourceName := fmt.Sprintf("%T/ResponseHeaders", f)
-		ctx, span := svc.tracer.Start(ctx, resourceName)
-		// span.AddAttributes(trace.StringAttribute("filter", fmt.Sprintf("%T", f)))
-
-		immediateResponse, err := f.ResponseHeaders(ctx, crw, req)
+		f, ok, immediateResponse, err := svc.resolveFilter(ctx, i, req)
 		if err != nil {
-			span.SetStatus(codes.Error, err.Error())
-			span.End()
-			return fmt.Errorf("ResponseHeaders: failed running filter %T: %w", f, err)
+			return err
 		}
 		if immediateResponse != nil {
-			span.End()
-			return procsrv.Send(&extproc.ProcessingResponse{
-				Response: immediateResponse,
-			})
+			inv := &Invocation{Filter: f, Stage: MatchResourceName, Request: req, Writer: crw}
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if !ok {
+			continue
+		}
+		inv := &Invocation{Filter: f, Stage: ResponseHeadersResourceName, Request: req, Writer: crw}
+		immediateResponse, err = svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return f.ResponseHeaders(ctx, crw, req)
+		})
+		if err != nil {
+			immediateResponse, err = svc.onFilterError(i, inv.Stage, f, err)
+			if err != nil {
+				return err
+			}
+		}
+		if immediateResponse != nil {
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
 		}
 		if err := crw.CommonResponse().Validate(); err != nil {
-			span.SetStatus(codes.Error, err.Error())
-			span.End()
 			return fmt.Errorf("ResponseHeaders: failed validating response in filter %T: %w", f, err)
 		}
-		span.End()
 	}
+	// Envoy only honours clear_route_cache in the request direction.
 	r := &extproc.ProcessingResponse{
 		Response: &extproc.ProcessingResponse_ResponseHeaders{
 			ResponseHeaders: &extproc.HeadersResponse{
-				Response: crw.CommonResponse(),
+				Response: crw.Coalesce().ClearRouteCache(false).CommonResponse(),
 			},
 		},
+		DynamicMetadata: svc.dynamicMetadata(req, crw),
 	}
 	if err := r.ValidateAll(); err != nil {
 		return fmt.Errorf("ResponseHeaders: failed validating response: %w", err)
@@ -287,10 +745,94 @@ func (svc *ExtProcessor) responseHeadersMessage(ctx context.Context, req *filter
 	return nil
 }
 
-// Step 5. (Not implemented) Response body: Sent according to the processing mode like the request body.
-func (svc *ExtProcessor) responseBodyMessage(_ context.Context, _ *filter.RequestContext, _ *extproc.ProcessingRequest_ResponseBody, procsrv extproc.ExternalProcessor_ProcessServer) error {
+// Step 5. Response body: Sent according to the processing mode like the request body.
+// Each message runs through the ResponseBodyChunkFilter and ResponseBodyFilter implementations in reverse filter order.
+func (svc *ExtProcessor) responseBodyMessage(ctx context.Context, req *filter.RequestContext, msg *extproc.ProcessingRequest_ResponseBody, chunks *chunkPipeline, procsrv extproc.ExternalProcessor_ProcessServer) error {
+	req.ResponseBody = msg.ResponseBody.GetBody()
+	endOfStream := msg.ResponseBody.GetEndOfStream()
+	_, responseTrailers := streamFromContext(ctx).trailerModes()
+	crw := newCommonResponseWriter(ctx, req.ResponseHeaders)
+	modified := false
+
+	for i := len(svc.filters) - 1; i >= 0; i-- {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		f, ok, immediateResponse, err := svc.resolveFilter(ctx, i, req)
+		if err != nil {
+			return err
+		}
+		if immediateResponse != nil {
+			inv := &Invocation{Filter: f, Stage: MatchResourceName, Request: req, Writer: crw}
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if !ok {
+			continue
+		}
+		if cf, ok := f.(filter.ResponseBodyChunkFilter); ok && (len(req.ResponseBody) > 0 || endOfStream) {
+			cw := chunks.writer(i, req.ResponseBody, endOfStream, responseTrailers)
+			inv := &Invocation{Filter: f, Stage: ResponseBodyChunkResourceName, Request: req}
+			_, err := svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+				if err := cf.ResponseBodyChunk(ctx, cw, req); err != nil {
+					return nil, err
+				}
+				return nil, cw.Err()
+			})
+			if err != nil {
+				immediateResponse, err := svc.onFilterError(i, inv.Stage, f, err)
+				if err != nil {
+					return err
+				}
+				if immediateResponse != nil {
+					return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+				}
+				// The error is ignored: the chunk received by the filter is passed unchanged to the next filter.
+				modified = modified || !bytes.Equal(cw.Chunk(), req.ResponseBody)
+				req.ResponseBody = cw.Chunk()
+				continue
+			}
+			modified = modified || cw.Modified() || !bytes.Equal(cw.Chunk(), req.ResponseBody)
+			req.ResponseBody = chunks.commit(i, cw)
+		}
+
+		bf, ok := f.(filter.ResponseBodyFilter)
+		if !ok {
+			continue
+		}
+		inv := &Invocation{Filter: f, Stage: ResponseBodyResourceName, Request: req, Writer: crw}
+		immediateResponse, err = svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return bf.ResponseBody(ctx, crw, req)
+		})
+		if err != nil {
+			immediateResponse, err = svc.onFilterError(i, inv.Stage, f, err)
+			if err != nil {
+				return err
+			}
+		}
+		if immediateResponse != nil {
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if err := crw.CommonResponse().Validate(); err != nil {
+			return fmt.Errorf("ResponseBody: failed validating response in filter %T: %w", f, err)
+		}
+		if body, ok := consumeBodyMutation(crw, req.ResponseBody); ok {
+			req.ResponseBody = body
+			modified = true
+		}
+	}
+	// Envoy only honours clear_route_cache in the request direction.
 	r := &extproc.ProcessingResponse{
-		Response: &extproc.ProcessingResponse_ResponseBody{},
+		Response: &extproc.ProcessingResponse_ResponseBody{
+			ResponseBody: &extproc.BodyResponse{
+				Response: bodyResponse(crw.ClearRouteCache(false), req.ResponseBody, modified),
+			},
+		},
+		DynamicMetadata: svc.dynamicMetadata(req, crw),
+	}
+	if err := r.ValidateAll(); err != nil {
+		return fmt.Errorf("ResponseBody: failed validating response: %w", err)
 	}
 	if err := procsrv.Send(r); err != nil {
 		return fmt.Errorf("ResponseBody: failed sending response: %w", err)
@@ -298,10 +840,73 @@ func (svc *ExtProcessor) responseBodyMessage(_ context.Context, _ *filter.Reques
 	return nil
 }
 
-// Step 6. (Not implemented) Response trailers: Delivered according to the processing mode like the request trailers.
-func (svc *ExtProcessor) responseTrailersMessage(_ context.Context, _ *filter.RequestContext, _ *extproc.ProcessingRequest_ResponseTrailers, procsrv extproc.ExternalProcessor_ProcessServer) error {
+// Step 6. Response trailers: Delivered according to the processing mode like the request trailers.
+// The trailers run through the ResponseTrailersFilter implementations in reverse filter order.
+func (svc *ExtProcessor) responseTrailersMessage(ctx context.Context, req *filter.RequestContext, msg *extproc.ProcessingRequest_ResponseTrailers, chunks *chunkPipeline, procsrv extproc.ExternalProcessor_ProcessServer) error {
+	if req.ResponseTrailers == nil {
+		req.ResponseTrailers = make(http.Header)
+	}
+	for _, header := range msg.ResponseTrailers.GetTrailers().GetHeaders() {
+		headerValue := filter.HeaderValueString(header)
+		req.ResponseTrailers.Add(header.Key, headerValue)
+	}
+	crw := newCommonResponseWriter(ctx, req.ResponseTrailers)
+	inv, immediateResponse, err := svc.discardHeldChunks(ResponseTrailersResourceName, req, crw, chunks)
+	if err != nil {
+		return err
+	}
+	if immediateResponse != nil {
+		return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+	}
+
+	for i := len(svc.filters) - 1; i >= 0; i-- {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		f, ok, immediateResponse, err := svc.resolveFilter(ctx, i, req)
+		if err != nil {
+			return err
+		}
+		if immediateResponse != nil {
+			inv := &Invocation{Filter: f, Stage: MatchResourceName, Request: req, Writer: crw}
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if !ok {
+			continue
+		}
+		tf, ok := f.(filter.ResponseTrailersFilter)
+		if !ok {
+			continue
+		}
+		inv := &Invocation{Filter: f, Stage: ResponseTrailersResourceName, Request: req, Writer: crw}
+		immediateResponse, err = svc.invoke(ctx, inv, func(ctx context.Context) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+			return tf.ResponseTrailers(ctx, crw, req)
+		})
+		if err != nil {
+			immediateResponse, err = svc.onFilterError(i, inv.Stage, f, err)
+			if err != nil {
+				return err
+			}
+		}
+		if immediateResponse != nil {
+			return svc.sendImmediateResponse(ctx, procsrv, inv, immediateResponse, crw)
+		}
+		if err := crw.CommonResponse().Validate(); err != nil {
+			return fmt.Errorf("ResponseTrailers: failed validating response in filter %T: %w", f, err)
+		}
+	}
 	r := &extproc.ProcessingResponse{
-		Response: &extproc.ProcessingResponse_ResponseTrailers{},
+		Response: &extproc.ProcessingResponse_ResponseTrailers{
+			ResponseTrailers: &extproc.TrailersResponse{
+				HeaderMutation: crw.Coalesce().CommonResponse().GetHeaderMutation(),
+			},
+		},
+		DynamicMetadata: svc.dynamicMetadata(req, crw),
+	}
+	if err := r.ValidateAll(); err != nil {
+		return fmt.Errorf("ResponseTrailers: failed validating response: %w", err)
 	}
 	if err := procsrv.Send(r); err != nil {
 		return fmt.Errorf("ResponseTrailers: failed sending response: %w", err)
@@ -309,6 +914,61 @@ func (svc *ExtProcessor) responseTrailersMessage(_ context.Context, _ *filter.Re
 	return nil
 }
 
+// discardHeldChunks drops the data still held back by the chunk filters when the trailers arrive, since it cannot be
+// sent with them, and applies the error policy of these filters to filter.ErrHoldWithTrailers. It returns the
+// invocation and the immediate response to send, or the error aborting the stream.
+func (svc *ExtProcessor) discardHeldChunks(stage string, req *filter.RequestContext, crw *filter.CommonResponseWriter, chunks *chunkPipeline) (*Invocation, *extproc.ProcessingResponse_ImmediateResponse, error) {
+	for _, i := range chunks.discard() {
+		f := unwrapFilter(svc.filters[i])
+		immediateResponse, err := svc.onFilterError(i, stage, f, filter.ErrHoldWithTrailers)
+		if err != nil || immediateResponse != nil {
+			return &Invocation{Filter: f, Stage: stage, Request: req, Writer: crw}, immediateResponse, err
+		}
+	}
+	return nil, nil, nil
+}
+
+// mutateBody returns the body as seen by the next filter after applying the given body mutation.
+func mutateBody(body []byte, m *extproc.BodyMutation) []byte {
+	switch mutation := m.GetMutation().(type) {
+	case *extproc.BodyMutation_Body:
+		return mutation.Body
+	case *extproc.BodyMutation_ClearBody:
+		if mutation.ClearBody {
+			return nil
+		}
+	}
+	return body
+}
+
+// consumeBodyMutation returns the body as seen by the next filter after applying the body mutation written by the last
+// body filter, and whether it wrote one. The mutation is reset so it is not applied again after the next filters, which
+// may change the body in between, e.g. chunk filters. The body sent to Envoy is set by bodyResponse.
+func consumeBodyMutation(crw *filter.CommonResponseWriter, body []byte) ([]byte, bool) {
+	m := crw.CommonResponse().GetBodyMutation()
+	if m.GetMutation() == nil {
+		return body, false
+	}
+	crw.CommonResponse().BodyMutation = nil
+	return mutateBody(body, m), true
+}
+
+// bodyResponse returns the CommonResponse to send in reply to a body message.
+// When the filters modified the body, the body mutation carries the body emitted by the last filter.
+// CommonResponseWriter.BodyMutation sets CONTINUE_AND_REPLACE, which is only meaningful in response to header
+// messages, so it is downgraded to CONTINUE.
+func bodyResponse(crw *filter.CommonResponseWriter, body []byte, modified bool) *extproc.CommonResponse {
+	if modified {
+		crw.BodyMutation(&extproc.BodyMutation{
+			Mutation: &extproc.BodyMutation_Body{Body: body},
+		})
+	}
+	if crw.CommonResponse().GetStatus() == extproc.CommonResponse_CONTINUE_AND_REPLACE {
+		crw.SetStatus(extproc.CommonResponse_CONTINUE)
+	}
+	return crw.Coalesce().CommonResponse()
+}
+
 // IgnoreCanceled returns nil if the error is a context.Canceled error or an io.EOF error.
 func IgnoreCanceled(err error) error {
 	switch {
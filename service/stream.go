@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extprocfilter "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+)
+
+// stream records what happened in a stream for the stream callbacks and the access log. It is carried by the context
+// of the stream.
+type stream struct {
+	start  time.Time
+	req    *filter.RequestContext
+	result filter.StreamResult
+	// accessLog reports whether the access log of the stream is written, see WithAccessLogSampleRate.
+	accessLog bool
+	// headerEncoding is the encoding of the header values written to Envoy, see WithHeaderEncoding.
+	headerEncoding filter.HeaderEncoding
+	// processingMode is the processing mode of the stream, see WithProcessingMode, after the mode override of the filters.
+	processingMode *extprocfilter.ProcessingMode
+	// matches holds whether the filter at each index matches the stream, once its conditions are evaluated.
+	matches map[int]bool
+}
+
+// newCommonResponseWriter returns a writer of the given headers using the header encoding of the stream of ctx.
+func newCommonResponseWriter(ctx context.Context, headers http.Header) *filter.CommonResponseWriter {
+	crw := filter.NewCommonResponseWriter(headers)
+	if s := streamFromContext(ctx); s != nil {
+		crw.SetHeaderEncoding(s.headerEncoding)
+	}
+	return crw
+}
+
+// matched returns whether the filter at index i matches the stream, and whether its conditions were evaluated.
+func (s *stream) matched(i int) (bool, bool) {
+	if s == nil {
+		return false, false
+	}
+	matched, ok := s.matches[i]
+	return matched, ok
+}
+
+// setMatched records whether the filter at index i matches the stream.
+func (s *stream) setMatched(i int, matched bool) {
+	if s == nil {
+		return
+	}
+	if s.matches == nil {
+		s.matches = make(map[int]bool)
+	}
+	s.matches[i] = matched
+}
+
+// trailerModes returns whether the request and response trailers are sent to the processor, as far as it is known.
+func (s *stream) trailerModes() (request bool, response bool) {
+	if s == nil {
+		return false, false
+	}
+	return s.processingMode.GetRequestTrailerMode() == extprocfilter.ProcessingMode_SEND,
+		s.processingMode.GetResponseTrailerMode() == extprocfilter.ProcessingMode_SEND
+}
+
+type streamKey struct{}
+
+func contextWithStream(ctx context.Context, s *stream) context.Context {
+	return context.WithValue(ctx, streamKey{}, s)
+}
+
+// streamFromContext returns the stream of ctx, or nil outside of ExtProcessor.Process.
+func streamFromContext(ctx context.Context) *stream {
+	s, _ := ctx.Value(streamKey{}).(*stream)
+	return s
+}
+
+// mutationMark marks the mutations of a writer before an invocation, to find the mutations made by the invocation.
+type mutationMark struct {
+	setHeaders    int
+	removeHeaders int
+	body          *extproc.BodyMutation
+}
+
+func markMutations(w *filter.CommonResponseWriter) mutationMark {
+	if w == nil {
+		return mutationMark{}
+	}
+	cr := w.CommonResponse()
+	return mutationMark{
+		setHeaders:    len(cr.GetHeaderMutation().GetSetHeaders()),
+		removeHeaders: len(w.RemovedHeaders()),
+		body:          cr.GetBodyMutation(),
+	}
+}
+
+// since returns the mutations made on w since the mark.
+func (m mutationMark) since(w *filter.CommonResponseWriter) (setHeaders []*corev3.HeaderValueOption, removeHeaders []string, body bool) {
+	if w == nil {
+		return nil, nil, false
+	}
+	cr := w.CommonResponse()
+	return cr.GetHeaderMutation().GetSetHeaders()[m.setHeaders:],
+		w.RemovedHeaders()[m.removeHeaders:],
+		cr.GetBodyMutation() != m.body
+}
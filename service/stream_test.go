@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+	grpcodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lifecycleFilter records the stream callbacks.
+type lifecycleFilter struct {
+	filter.NoOpFilter
+	events []string
+	result *filter.StreamResult
+}
+
+func (f *lifecycleFilter) OnStreamStart(_ *filter.RequestContext) {
+	f.events = append(f.events, "start")
+}
+
+func (f *lifecycleFilter) OnStreamMessage(_ *filter.RequestContext, stage string) {
+	f.events = append(f.events, "message:"+stage)
+}
+
+func (f *lifecycleFilter) OnImmediateResponse(_ *filter.RequestContext, resp *extproc.ImmediateResponse) {
+	f.events = append(f.events, "immediate:"+http.StatusText(int(resp.GetStatus().GetCode())))
+}
+
+func (f *lifecycleFilter) OnStreamError(_ *filter.RequestContext, err error) {
+	f.events = append(f.events, "error:"+err.Error())
+}
+
+func (f *lifecycleFilter) OnStreamCancel(_ *filter.RequestContext) {
+	f.events = append(f.events, "cancel")
+}
+
+func (f *lifecycleFilter) OnStreamResult(_ *filter.RequestContext, result *filter.StreamResult) error {
+	f.events = append(f.events, "complete")
+	f.result = result
+	return nil
+}
+
+// streamFilter implements both Stream and StreamResultCallback.
+type streamFilter struct {
+	lifecycleFilter
+}
+
+func (f *streamFilter) OnStreamComplete(_ *filter.RequestContext) error {
+	f.events = append(f.events, "stream complete")
+	return nil
+}
+
+// canceledProcessServer is canceled by Envoy after replaying its requests.
+type canceledProcessServer struct {
+	*fakeProcessServer
+}
+
+func (s *canceledProcessServer) Recv() (*extproc.ProcessingRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, status.Error(grpcodes.Canceled, context.Canceled.Error())
+	}
+	return s.fakeProcessServer.Recv()
+}
+
+func TestStreamCallbacks(t *testing.T) {
+	requestHeaders := &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{}},
+	}
+	responseHeaders := &extproc.ProcessingRequest{
+		Request: &extproc.ProcessingRequest_ResponseHeaders{ResponseHeaders: &extproc.HttpHeaders{}},
+	}
+
+	t.Run("complete stream", func(t *testing.T) {
+		f := &lifecycleFilter{}
+		require.NoError(t, New(WithFilters(f)).Process(newFakeProcessServer(requestHeaders, responseHeaders)))
+
+		require.Equal(t, []string{"start", "message:RequestHeaders", "message:ResponseHeaders", "complete"}, f.events)
+		require.Equal(t, []string{RequestHeadersResourceName, ResponseHeadersResourceName}, f.result.Stages)
+		require.False(t, f.result.ShortCircuited())
+		require.NoError(t, f.result.Err)
+		require.False(t, f.result.Canceled)
+		require.Positive(t, f.result.Duration)
+	})
+
+	t.Run("stream and result callbacks", func(t *testing.T) {
+		f := &streamFilter{}
+		require.NoError(t, New(WithFilters(f)).Process(newFakeProcessServer(requestHeaders)))
+		require.Equal(t, []string{"start", "message:RequestHeaders", "stream complete", "complete"}, f.events)
+	})
+
+	t.Run("immediate response", func(t *testing.T) {
+		f := &lifecycleFilter{}
+		abort := filter.WithErrorPolicy(&failingFilter{err: filter.AbortWithStatus(http.StatusForbidden)}, filter.FailClosed)
+		require.NoError(t, New(WithFilters(f, abort)).Process(newFakeProcessServer(requestHeaders)))
+
+		require.Equal(t, []string{"start", "immediate:Forbidden", "message:RequestHeaders", "complete"}, f.events)
+		require.True(t, f.result.ShortCircuited())
+		require.IsType(t, &failingFilter{}, f.result.ShortCircuitFilter)
+		require.Equal(t, RequestHeadersResourceName, f.result.ShortCircuitStage)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		f := &lifecycleFilter{}
+		errFailed := errors.New("failed")
+		err := New(WithFilters(f, &failingFilter{err: errFailed})).Process(newFakeProcessServer(requestHeaders))
+		require.ErrorIs(t, err, errFailed)
+
+		require.Equal(t, []string{"start", "error:" + err.Error(), "complete"}, f.events)
+		require.ErrorIs(t, f.result.Err, errFailed)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		f := &lifecycleFilter{}
+		srv := &canceledProcessServer{newFakeProcessServer(requestHeaders)}
+		require.NoError(t, New(WithFilters(f)).Process(srv))
+
+		require.Equal(t, []string{"start", "message:RequestHeaders", "cancel", "complete"}, f.events)
+		require.True(t, f.result.Canceled)
+	})
+}
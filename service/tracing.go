@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// defaultPropagator extracts the W3C trace context and the B3 single and multiple headers.
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}, b3.New())
+
+// immediateResponseStatusKey is the span attribute holding the status code of an immediate response.
+const immediateResponseStatusKey = attribute.Key("extproc.immediate_response.status_code")
+
+// extractTraceContext returns ctx with the remote span context extracted from carrier, if ctx has no span context yet.
+// It reports whether the returned ctx has a span context.
+func (svc *ExtProcessor) extractTraceContext(ctx context.Context, carrier propagation.TextMapCarrier) (context.Context, bool) {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, true
+	}
+	ctx = svc.propagator.Extract(ctx, carrier)
+	return ctx, trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// spanAttributes returns the attributes of the HTTP request known so far.
+func spanAttributes(req *filter.RequestContext) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if method := req.Method(); method != "" {
+		attrs = append(attrs, semconv.HTTPRequestMethodKey.String(method))
+	}
+	if authority := req.Authority(); authority != "" {
+		attrs = append(attrs, semconv.ServerAddress(authority))
+	}
+	if req.RequestHeader(":path") != "" {
+		attrs = append(attrs, semconv.URLPath(req.URL().Path))
+	}
+	if id := req.RequestID(); id != "" {
+		attrs = append(attrs, attribute.StringSlice("http.request.header.x-request-id", []string{id}))
+	}
+	if status := req.Status(); status != 0 {
+		attrs = append(attrs, semconv.HTTPResponseStatusCode(status))
+	}
+	return attrs
+}
+
+// metadataCarrier adapts gRPC metadata to a propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// headerMapCarrier adapts the headers sent by Envoy to a read-only propagation.TextMapCarrier.
+type headerMapCarrier struct {
+	headers *corev3.HeaderMap
+}
+
+func (c headerMapCarrier) Get(key string) string {
+	for _, h := range c.headers.GetHeaders() {
+		if strings.EqualFold(h.GetKey(), key) {
+			return filter.HeaderValueString(h)
+		}
+	}
+	return ""
+}
+
+func (c headerMapCarrier) Set(string, string) {}
+
+func (c headerMapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.headers.GetHeaders()))
+	for _, h := range c.headers.GetHeaders() {
+		keys = append(keys, h.GetKey())
+	}
+	return keys
+}
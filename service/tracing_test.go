@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTraceContext(t *testing.T) {
+	const (
+		headerTraceID   = "4bf92f3577b34da6a3ce929d0e0e4736"
+		metadataTraceID = "0af7651916cd43dd8448eb211c80319c"
+	)
+	requestHeaders := func(headers ...*corev3.HeaderValue) *extproc.ProcessingRequest {
+		headers = append(headers,
+			&corev3.HeaderValue{Key: ":method", RawValue: []byte(http.MethodGet)},
+			&corev3.HeaderValue{Key: ":authority", RawValue: []byte("example.com")},
+			&corev3.HeaderValue{Key: ":path", RawValue: []byte("/users?id=1")},
+		)
+		return &extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &extproc.HttpHeaders{
+				Headers: &corev3.HeaderMap{Headers: headers},
+			}},
+		}
+	}
+	traceparent := func(traceID string) string {
+		return "00-" + traceID + "-00f067aa0ba902b7-01"
+	}
+	process := func(t *testing.T, ctx context.Context, req *extproc.ProcessingRequest) []sdktrace.ReadOnlySpan {
+		recorder := tracetest.NewSpanRecorder()
+		tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+		srv := newFakeProcessServer(req)
+		srv.ctx = ctx
+		require.NoError(t, New(WithFilters(&setHeaderFilter{}), WithTracer(tracer)).Process(srv))
+		return recorder.Ended()
+	}
+
+	t.Run("spans join the trace of the request headers", func(t *testing.T) {
+		spans := process(t, context.Background(), requestHeaders(
+			&corev3.HeaderValue{Key: "traceparent", RawValue: []byte(traceparent(headerTraceID))},
+		))
+		require.Len(t, spans, 2)
+		for _, span := range spans {
+			require.Equal(t, headerTraceID, span.SpanContext().TraceID().String())
+		}
+		filterSpan, stageSpan := spans[0], spans[1]
+		require.Equal(t, "*service.setHeaderFilter/RequestHeaders", filterSpan.Name())
+		require.Equal(t, stageSpan.SpanContext().SpanID(), filterSpan.Parent().SpanID())
+		require.True(t, stageSpan.Parent().IsRemote())
+		require.Subset(t, stageSpan.Attributes(), []attribute.KeyValue{
+			attribute.String("http.request.method", http.MethodGet),
+			attribute.String("server.address", "example.com"),
+			attribute.String("url.path", "/users"),
+		})
+	})
+
+	t.Run("spans join the trace of the gRPC metadata", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", traceparent(metadataTraceID)))
+		spans := process(t, ctx, requestHeaders(
+			&corev3.HeaderValue{Key: "traceparent", RawValue: []byte(traceparent(headerTraceID))},
+		))
+		for _, span := range spans {
+			require.Equal(t, metadataTraceID, span.SpanContext().TraceID().String())
+		}
+	})
+
+	t.Run("spans join the B3 trace of the request headers", func(t *testing.T) {
+		spans := process(t, context.Background(), requestHeaders(
+			&corev3.HeaderValue{Key: "x-b3-traceid", RawValue: []byte(headerTraceID)},
+			&corev3.HeaderValue{Key: "x-b3-spanid", RawValue: []byte("00f067aa0ba902b7")},
+			&corev3.HeaderValue{Key: "x-b3-sampled", RawValue: []byte("1")},
+		))
+		for _, span := range spans {
+			require.Equal(t, headerTraceID, span.SpanContext().TraceID().String())
+		}
+	})
+
+	t.Run("immediate response status is recorded", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+		srv := newFakeProcessServer(&extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_ResponseBody{ResponseBody: &extproc.HttpBody{Body: []byte("deny"), EndOfStream: true}},
+		})
+		require.NoError(t, New(WithFilters(&bodyFilter{}), WithTracer(tracer)).Process(srv))
+
+		spans := recorder.Ended()
+		stageSpan := spans[len(spans)-1]
+		require.Equal(t, ResponseBodyResourceName, stageSpan.Name())
+		require.Contains(t, stageSpan.Attributes(), immediateResponseStatusKey.Int(http.StatusForbidden))
+	})
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extproc "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/getyourguide/extproc-go/filter"
+	"github.com/stretchr/testify/require"
+)
+
+type trailersFilter struct {
+	filter.NoOpFilter
+	grpcStatus string
+}
+
+func (f *trailersFilter) RequestTrailers(_ context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	crw.RemoveHeaders("x-checksum")
+	return nil, nil
+}
+
+func (f *trailersFilter) ResponseTrailers(_ context.Context, crw *filter.CommonResponseWriter, req *filter.RequestContext) (*extproc.ProcessingResponse_ImmediateResponse, error) {
+	f.grpcStatus = req.ResponseTrailer("grpc-status")
+	if f.grpcStatus != "0" {
+		crw.SetHeader("grpc-message", "upstream failed")
+	}
+	return nil, nil
+}
+
+func TestTrailersFilters(t *testing.T) {
+	f := &trailersFilter{}
+	svc := New(WithFilters(f))
+	srv := newFakeProcessServer(
+		&extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_RequestTrailers{
+				RequestTrailers: &extproc.HttpTrailers{Trailers: &corev3.HeaderMap{
+					Headers: []*corev3.HeaderValue{{Key: "x-checksum", RawValue: []byte("abc")}},
+				}},
+			},
+		},
+		&extproc.ProcessingRequest{
+			Request: &extproc.ProcessingRequest_ResponseTrailers{
+				ResponseTrailers: &extproc.HttpTrailers{Trailers: &corev3.HeaderMap{
+					Headers: []*corev3.HeaderValue{{Key: "grpc-status", RawValue: []byte("13")}},
+				}},
+			},
+		},
+	)
+	require.NoError(t, svc.Process(srv))
+
+	require.Len(t, srv.responses, 2)
+	require.Equal(t, []string{"x-checksum"}, srv.responses[0].GetRequestTrailers().GetHeaderMutation().GetRemoveHeaders())
+
+	require.Equal(t, "13", f.grpcStatus)
+	setHeaders := srv.responses[1].GetResponseTrailers().GetHeaderMutation().GetSetHeaders()
+	require.Len(t, setHeaders, 1)
+	require.Equal(t, "grpc-message", setHeaders[0].GetHeader().GetKey())
+	require.Equal(t, "upstream failed", string(setHeaders[0].GetHeader().GetRawValue()))
+}